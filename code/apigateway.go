@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// apiGatewayExecutionLogGroupRegex matches the log groups API Gateway writes
+// the execution logs of a REST API stage to.
+var apiGatewayExecutionLogGroupRegex = regexp.MustCompile(`^API-Gateway-Execution-Logs_[a-z0-9]+/`)
+
+// apiGatewayExecutionRequestIDRegex captures the request ID execution logs
+// start with.
+var apiGatewayExecutionRequestIDRegex = regexp.MustCompile(`^\((?P<requestId>[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\) `)
+
+// apiGatewayCLFAccessLogRegex matches access logs in the Common Log Format
+// proposed by the API Gateway console, which ends with the request ID.
+var apiGatewayCLFAccessLogRegex = regexp.MustCompile(`^(?P<ip>\S+) \S+ \S+ \[(?P<requestTime>[^\]]+)\] "(?:(?P<httpMethod>[A-Z]+) )?(?P<resourcePath>\S+) (?P<protocol>\S+)" (?P<status>\d{3}) (?P<responseLength>\S+) (?P<requestId>\S+)$`)
+
+// apiGatewayAccessLogFields are the $context variables of JSON access logs
+// promoted to attributes, under the names the API Gateway console uses for
+// them.
+var apiGatewayAccessLogFields = []string{
+	"requestId",
+	"httpMethod",
+	"status",
+	"responseLatency",
+	"integrationLatency",
+	"routeKey",
+	"resourcePath",
+}
+
+// apiGatewayAccessLogGroups are the names or prefixes of the CloudWatch log
+// groups API Gateway stages write their access logs to.
+var apiGatewayAccessLogGroups []string
+
+// isAPIGatewayAccessLogGroup reports whether a CloudWatch log group receives
+// API Gateway access logs.
+func isAPIGatewayAccessLogGroup(logGroup string) bool {
+	return hasLogGroupPrefix(logGroup, apiGatewayAccessLogGroups)
+}
+
+// isAPIGatewayExecutionLogGroup reports whether a CloudWatch log group
+// receives API Gateway execution logs.
+func isAPIGatewayExecutionLogGroup(logGroup string) bool {
+	return apiGatewayExecutionLogGroupRegex.MatchString(logGroup)
+}
+
+// parseAPIGatewayExecutionLog returns the request ID of an execution log as
+// attribute, or nil when the log has none.
+func parseAPIGatewayExecutionLog(message string) map[string]string {
+	matches := apiGatewayExecutionRequestIDRegex.FindStringSubmatch(message)
+	if matches == nil {
+		return nil
+	}
+	return map[string]string{
+		"requestId": matches[apiGatewayExecutionRequestIDRegex.SubexpIndex("requestId")],
+	}
+}
+
+// parseAPIGatewayAccessLog returns the request ID, method, status, latency
+// and route of an access log in JSON or the Common Log Format as attributes.
+// It returns nil for messages that are not access logs.
+func parseAPIGatewayAccessLog(message string) map[string]string {
+	if strings.HasPrefix(message, "{") {
+		return parseAPIGatewayJSONAccessLog(message)
+	}
+
+	matches := apiGatewayCLFAccessLogRegex.FindStringSubmatch(message)
+	if matches == nil {
+		return nil
+	}
+	fields := make(map[string]string)
+	for _, key := range []string{"requestId", "httpMethod", "status", "resourcePath"} {
+		if value := matches[apiGatewayCLFAccessLogRegex.SubexpIndex(key)]; value != "-" {
+			fields[key] = value
+		}
+	}
+	return attributes(fields)
+}
+
+// parseAPIGatewayJSONAccessLog reads the attributes of a JSON access log,
+// which must at least log the request ID and status.
+func parseAPIGatewayJSONAccessLog(message string) map[string]string {
+	if !strings.Contains(message, `"requestId"`) {
+		return nil
+	}
+
+	var record map[string]json.RawMessage
+	err := json.Unmarshal([]byte(message), &record)
+	if err != nil {
+		return nil
+	}
+	if _, ok := record["status"]; !ok {
+		return nil
+	}
+
+	fields := make(map[string]string)
+	for _, key := range apiGatewayAccessLogFields {
+		if value := apiGatewayAccessLogValue(record[key]); value != "-" {
+			fields[key] = value
+		}
+	}
+	metadata := attributes(fields)
+	if metadata["requestId"] == "" {
+		return nil
+	}
+	return metadata
+}
+
+// apiGatewayAccessLogValue returns a JSON access log value as text, whether
+// the format quotes the $context variable or not.
+func apiGatewayAccessLogValue(raw json.RawMessage) string {
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" || raw[0] == '{' || raw[0] == '[' {
+		return ""
+	}
+	return string(raw)
+}
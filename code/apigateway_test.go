@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAPIGatewayAccessLog(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		message := `{"requestId":"c6af9ac6-7b61-11e6-9a41-93e8deadbeef","ip":"198.51.100.7","httpMethod":"POST","resourcePath":"/orders/{id}","status":"201","protocol":"HTTP/1.1","responseLength":"48","responseLatency":87,"integrationLatency":"-"}`
+		assert.Equal(t, map[string]string{
+			"requestId":       "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+			"httpMethod":      "POST",
+			"status":          "201",
+			"responseLatency": "87",
+			"resourcePath":    "/orders/{id}",
+		}, parseAPIGatewayAccessLog(message))
+
+		httpAPIMessage := `{"requestId":"JTHd9jE9oAMEV1Q=","routeKey":"GET /pets","status":200,"responseLatency":"12"}`
+		assert.Equal(t, map[string]string{
+			"requestId":       "JTHd9jE9oAMEV1Q=",
+			"routeKey":        "GET /pets",
+			"status":          "200",
+			"responseLatency": "12",
+		}, parseAPIGatewayAccessLog(httpAPIMessage))
+	})
+
+	t.Run("clf", func(t *testing.T) {
+		message := `198.51.100.7 - - [26/Apr/2021:05:51:34 +0000] "GET /pets HTTP/1.1" 200 1024 c6af9ac6-7b61-11e6-9a41-93e8deadbeef`
+		assert.Equal(t, map[string]string{
+			"requestId":    "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+			"httpMethod":   "GET",
+			"status":       "200",
+			"resourcePath": "/pets",
+		}, parseAPIGatewayAccessLog(message))
+
+		defaultRoute := `198.51.100.7 - - [26/Apr/2021:05:51:34 +0000] "$default HTTP/1.1" 404 0 JTHd9jE9oAMEV1Q=`
+		assert.Equal(t, "$default", parseAPIGatewayAccessLog(defaultRoute)["resourcePath"])
+	})
+
+	t.Run("other logs", func(t *testing.T) {
+		for _, message := range []string{
+			`198.51.100.7 - - [26/Apr/2021:05:51:34 +0000] "GET /index.html HTTP/1.1" 200 1024 "-" "curl/7.76.0"`,
+			`{"level":"info","requestId":"c6af9ac6","msg":"started"}`,
+			`{"status":"ok"}`,
+			`START RequestId: 6e8b7a4c`,
+		} {
+			assert.Nil(t, parseAPIGatewayAccessLog(message), message)
+		}
+	})
+}
+
+func TestParseAPIGatewayCloudWatchLogs(t *testing.T) {
+	awsRegion = "ap-northeast-1"
+	defer func() { awsRegion = "" }()
+
+	t.Run("execution logs", func(t *testing.T) {
+		lmEvents := parseCloudWatchLogs(cloudWatchEventFor(events.CloudwatchLogsData{
+			Owner:     "197152445587",
+			LogGroup:  "API-Gateway-Execution-Logs_a1b2c3d4e5/prod",
+			LogStream: "c4ca4238a0b923820dcc509a6f75849b",
+			LogEvents: []events.CloudwatchLogsLogEvent{
+				{ID: "1", Timestamp: 1619416294250, Message: "(c6af9ac6-7b61-11e6-9a41-93e8deadbeef) Method request path: {id=42}"},
+				{ID: "2", Timestamp: 1619416294300, Message: "Starting execution for request"},
+			},
+		}))
+
+		assert.Equal(t, 2, len(lmEvents))
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:apigateway:ap-northeast-1::/restapis/a1b2c3d4e5/stages/prod"}, lmEvents[0].ResourceID)
+		assert.Equal(t, map[string]string{"requestId": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef"}, lmEvents[0].Metadata)
+		assert.Nil(t, lmEvents[1].Metadata)
+	})
+
+	t.Run("access logs", func(t *testing.T) {
+		apiGatewayAccessLogGroups = parseCommaSeparated("/apigateway/")
+		defer func() { apiGatewayAccessLogGroups = nil }()
+
+		lmEvents := parseCloudWatchLogs(cloudWatchEventFor(events.CloudwatchLogsData{
+			Owner:     "197152445587",
+			LogGroup:  "/apigateway/storefront/access",
+			LogStream: "c4ca4238a0b923820dcc509a6f75849b",
+			LogEvents: []events.CloudwatchLogsLogEvent{
+				{ID: "1", Timestamp: 1619416294250, Message: `{"requestId":"c6af9ac6-7b61-11e6-9a41-93e8deadbeef","httpMethod":"GET","resourcePath":"/pets","status":"200","responseLatency":"12"}`},
+			},
+		}))
+
+		assert.Equal(t, 1, len(lmEvents))
+		assert.Equal(t, accountResourceID("197152445587"), lmEvents[0].ResourceID)
+		assert.Equal(t, "12", lmEvents[0].Metadata["responseLatency"])
+	})
+
+	t.Run("other log groups", func(t *testing.T) {
+		lmEvents := parseCloudWatchLogs(cloudWatchEventFor(events.CloudwatchLogsData{
+			Owner:     "197152445587",
+			LogGroup:  "/aws/lambda/checkout",
+			LogStream: "2021/04/26/[$LATEST]0123456789abcdef",
+			LogEvents: []events.CloudwatchLogsLogEvent{
+				{ID: "1", Timestamp: 1619416294250, Message: `{"requestId":"c6af9ac6-7b61-11e6-9a41-93e8deadbeef","status":"200"}`},
+			},
+		}))
+
+		assert.Equal(t, 1, len(lmEvents))
+		assert.Nil(t, lmEvents[0].Metadata)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	arnServiceRegex   = regexp.MustCompile(`^[a-z0-9-]+$`)
+	arnRegionRegex    = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+	arnAccountIDRegex = regexp.MustCompile(`^(\d{12}|aws)$`)
+)
+
+var arnPartitions = map[string]bool{
+	"aws":        true,
+	"aws-cn":     true,
+	"aws-us-gov": true,
+	"aws-iso":    true,
+	"aws-iso-b":  true,
+}
+
+// partitionForRegion returns the partition of an AWS region, defaulting to
+// the standard partition when the region is empty or unknown.
+func partitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	default:
+		return "aws"
+	}
+}
+
+// buildARN returns the ARN of a regional resource, in the partition of its
+// region.
+func buildARN(service string, region string, accountID string, resource string) (string, error) {
+	return formatARN(partitionForRegion(region), service, region, accountID, resource)
+}
+
+// buildGlobalARN returns the ARN of a resource whose ARN has no region, such
+// as an S3 bucket or an IAM role, in the partition of the given region.
+func buildGlobalARN(service string, region string, accountID string, resource string) (string, error) {
+	return formatARN(partitionForRegion(region), service, "", accountID, resource)
+}
+
+func formatARN(partition string, service string, region string, accountID string, resource string) (string, error) {
+	arn := fmt.Sprintf("arn:%s:%s:%s:%s:%s", partition, service, region, accountID, resource)
+	err := validateARNComponents(partition, service, region, accountID, resource)
+	if err != nil {
+		return "", fmt.Errorf("invalid arn %s: %s", arn, err)
+	}
+	return arn, nil
+}
+
+// validateARN checks an ARN built elsewhere, such as from a resource mapping
+// rule template.
+func validateARN(arn string) error {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return fmt.Errorf("invalid arn %s: expected arn:partition:service:region:account:resource", arn)
+	}
+
+	err := validateARNComponents(parts[1], parts[2], parts[3], parts[4], parts[5])
+	if err != nil {
+		return fmt.Errorf("invalid arn %s: %s", arn, err)
+	}
+	return nil
+}
+
+func validateARNComponents(partition string, service string, region string, accountID string, resource string) error {
+	if !arnPartitions[partition] {
+		return fmt.Errorf("unknown partition %q", partition)
+	}
+	if !arnServiceRegex.MatchString(service) {
+		return fmt.Errorf("invalid service %q", service)
+	}
+	if region != "" && !arnRegionRegex.MatchString(region) {
+		return fmt.Errorf("invalid region %q", region)
+	}
+	if region != "" && partitionForRegion(region) != partition {
+		return fmt.Errorf("region %q is not in partition %q", region, partition)
+	}
+	if accountID != "" && !arnAccountIDRegex.MatchString(accountID) {
+		return fmt.Errorf("invalid account id %q", accountID)
+	}
+	if strings.TrimSpace(resource) == "" {
+		return fmt.Errorf("missing resource")
+	}
+	return nil
+}
+
+// accountResourceID maps logs without a more specific resource to the AWS
+// account they come from.
+func accountResourceID(accountID string) map[string]string {
+	return map[string]string{
+		"system.aws.accountid":  accountID,
+		"system.cloud.category": "AWS/LMAccount",
+	}
+}
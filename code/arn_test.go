@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionForRegion(t *testing.T) {
+	partitions := map[string]string{
+		"":               "aws",
+		"us-west-1":      "aws",
+		"eu-central-1":   "aws",
+		"cn-north-1":     "aws-cn",
+		"cn-northwest-1": "aws-cn",
+		"us-gov-west-1":  "aws-us-gov",
+		"us-gov-east-1":  "aws-us-gov",
+		"us-iso-east-1":  "aws-iso",
+		"us-isob-east-1": "aws-iso-b",
+	}
+	for region, partition := range partitions {
+		assert.Equal(t, partition, partitionForRegion(region), region)
+	}
+}
+
+func TestBuildARN(t *testing.T) {
+	resources := []struct {
+		service  string
+		resource string
+	}{
+		{"ec2", "instance/i-067b718e521cdf437"},
+		{"rds", "db:database-1"},
+		{"lambda", "function:observatory-worker"},
+		{"firehose", "deliverystream/dataFirehose"},
+		{"kinesis", "stream/kinesisTestSream"},
+		{"ecs", "cluster/CVTestCluster"},
+		{"eks", "cluster/ak-eks-logs"},
+		{"elasticloadbalancing", "loadbalancer/app/test/50dc6c495c0c9188"},
+	}
+
+	for _, region := range []string{"us-west-1", "cn-north-1", "us-gov-west-1"} {
+		partition := partitionForRegion(region)
+		for _, r := range resources {
+			arn, err := buildARN(r.service, region, "197152445587", r.resource)
+			assert.NoError(t, err)
+			assert.Equal(t, "arn:"+partition+":"+r.service+":"+region+":197152445587:"+r.resource, arn)
+			assert.NoError(t, validateARN(arn))
+		}
+	}
+
+	t.Run("s3 bucket", func(t *testing.T) {
+		arn, err := buildGlobalARN("s3", "us-west-1", "", "LogBucket")
+		assert.NoError(t, err)
+		assert.Equal(t, "arn:aws:s3:::LogBucket", arn)
+
+		arn, err = buildGlobalARN("s3", "cn-northwest-1", "", "LogBucket")
+		assert.NoError(t, err)
+		assert.Equal(t, "arn:aws-cn:s3:::LogBucket", arn)
+	})
+
+	t.Run("without region", func(t *testing.T) {
+		arn, err := buildARN("ec2", "", "664833354492", "instance/i-01fb3c5139e4b27bb")
+		assert.NoError(t, err)
+		assert.Equal(t, "arn:aws:ec2::664833354492:instance/i-01fb3c5139e4b27bb", arn)
+	})
+
+	t.Run("invalid components", func(t *testing.T) {
+		_, err := buildARN("ec2", "us-west-1", "1971524455", "instance/i-067b718e521cdf437")
+		assert.Error(t, err)
+
+		_, err = buildARN("ec2", "US West", "197152445587", "instance/i-067b718e521cdf437")
+		assert.Error(t, err)
+
+		_, err = buildARN("EC2", "us-west-1", "197152445587", "instance/i-067b718e521cdf437")
+		assert.Error(t, err)
+
+		_, err = buildARN("ec2", "us-west-1", "197152445587", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateARN(t *testing.T) {
+	assert.NoError(t, validateARN("arn:aws:s3:::aws-cloudtrail-logs-700010466334-8d075b05"))
+	assert.NoError(t, validateARN("arn:aws-us-gov:lambda:us-gov-west-1:197152445587:function:worker"))
+	assert.NoError(t, validateARN("arn:aws:iam::aws:policy/ReadOnlyAccess"))
+	assert.Error(t, validateARN("arn:aws:lambda:us-gov-west-1:197152445587:function:worker"))
+	assert.Error(t, validateARN("arn:aws:lambda:us-west-1:197152445587"))
+	assert.Error(t, validateARN("arn:aws-moon:lambda:us-west-1:197152445587:function:worker"))
+}
+
+func TestResolveResourceIDPartition(t *testing.T) {
+	awsRegion = "us-gov-west-1"
+	defer func() { awsRegion = "" }()
+
+	data := events.CloudwatchLogsData{
+		Owner:     "197152445587",
+		LogGroup:  "/aws/lambda/observatory-worker",
+		LogStream: "2020/08/27/[$LATEST]35e3cc72b98d40b2b2f8843f574dcebf",
+	}
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws-us-gov:lambda:us-gov-west-1:197152445587:function:observatory-worker"}, resolveResourceID(data, "", "START"))
+
+	t.Run("invalid arn falls back to account", func(t *testing.T) {
+		data.Owner = "unknown"
+		assert.Equal(t, accountResourceID("unknown"), resolveResourceID(data, "", "START"))
+	})
+}
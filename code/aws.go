@@ -2,16 +2,28 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 )
 
 type GetContentFromS3Bucket func(string, string) string
 
+// GetS3ObjectMetadata returns the user-defined metadata of an S3 object, keyed
+// by lower-case name without the x-amz-meta- prefix.
+type GetS3ObjectMetadata func(bucketName string, fileName string) (map[string]string, error)
+
+// GetCloudTrailPublicKeys returns the public keys CloudTrail signed digest
+// files with in a region over a time range, keyed by fingerprint.
+type GetCloudTrailPublicKeys func(region string, startTime time.Time, endTime time.Time) (map[string][]byte, error)
+
 func getSecretValue(secretArn string) string {
 	session := session.Must(session.NewSession())
 	secManager := secretsmanager.New(session)
@@ -37,6 +49,76 @@ func getContentsFromS3Bucket(bucketName string, fileName string) string {
 	return readCloserToString(s3ObjectOutput.Body)
 }
 
+func getS3ObjectMetadata(bucketName string, fileName string) (map[string]string, error) {
+	session := session.Must(session.NewSession())
+	s3Manager := s3.New(session)
+	headObjectOutput, err := s3Manager.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string)
+	for name, value := range headObjectOutput.Metadata {
+		metadata[strings.ToLower(name)] = aws.StringValue(value)
+	}
+	return metadata, nil
+}
+
+func getCloudTrailPublicKeys(region string, startTime time.Time, endTime time.Time) (map[string][]byte, error) {
+	session := session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))
+	cloudTrailManager := cloudtrail.New(session)
+
+	keysByFingerprint := make(map[string][]byte)
+	err := cloudTrailManager.ListPublicKeysPages(&cloudtrail.ListPublicKeysInput{
+		StartTime: aws.Time(startTime),
+		EndTime:   aws.Time(endTime),
+	}, func(page *cloudtrail.ListPublicKeysOutput, lastPage bool) bool {
+		for _, key := range page.PublicKeyList {
+			keysByFingerprint[aws.StringValue(key.Fingerprint)] = key.Value
+		}
+		return true
+	})
+
+	return keysByFingerprint, err
+}
+
+func getResourceTags(region string, arns []string) (map[string]map[string]string, error) {
+	session := session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))
+	taggingManager := resourcegroupstaggingapi.New(session)
+
+	tagsByARN := make(map[string]map[string]string)
+	err := taggingManager.GetResourcesPages(&resourcegroupstaggingapi.GetResourcesInput{
+		ResourceARNList: aws.StringSlice(arns),
+	}, func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+		for _, resource := range page.ResourceTagMappingList {
+			tags := make(map[string]string)
+			for _, tag := range resource.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			tagsByARN[aws.StringValue(resource.ResourceARN)] = tags
+		}
+		return true
+	})
+
+	return tagsByARN, err
+}
+
+func getBucketRegion(bucket string) (string, error) {
+	session := session.Must(session.NewSession())
+	s3Manager := s3.New(session)
+	locationOutput, err := s3Manager.GetBucketLocation(&s3.GetBucketLocationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return s3.NormalizeBucketLocation(aws.StringValue(locationOutput.LocationConstraint)), nil
+}
+
 func convertToCloudWatchLogsEvent(m interface{}) events.CloudwatchLogsEvent {
 	data, err := json.Marshal(m)
 	handleFatalError("failed to marshal s3 event", err)
@@ -48,7 +130,55 @@ func convertToCloudWatchLogsEvent(m interface{}) events.CloudwatchLogsEvent {
 	return result
 }
 
+func convertToKinesisEvent(m interface{}) events.KinesisEvent {
+	data, err := json.Marshal(m)
+	handleFatalError("failed to marshal kinesis event", err)
+
+	var result events.KinesisEvent
+	err = json.Unmarshal(data, &result)
+	handleFatalError("failed to unmarshal kinesis event", err)
+
+	return result
+}
+
+func convertToEventBridgeEvent(m interface{}) events.CloudWatchEvent {
+	data, err := json.Marshal(m)
+	handleFatalError("failed to marshal eventbridge event", err)
+
+	var result events.CloudWatchEvent
+	err = json.Unmarshal(data, &result)
+	handleFatalError("failed to unmarshal eventbridge event", err)
+
+	return result
+}
+
+func convertToFirehoseEvent(m interface{}) events.KinesisFirehoseEvent {
+	data, err := json.Marshal(m)
+	handleFatalError("failed to marshal firehose event", err)
+
+	var result events.KinesisFirehoseEvent
+	err = json.Unmarshal(data, &result)
+	handleFatalError("failed to unmarshal firehose event", err)
+
+	return result
+}
+
+func convertToFunctionURLRequest(m interface{}) events.APIGatewayV2HTTPRequest {
+	data, err := json.Marshal(m)
+	handleFatalError("failed to marshal function url request", err)
+
+	var result events.APIGatewayV2HTTPRequest
+	err = json.Unmarshal(data, &result)
+	handleFatalError("failed to unmarshal function url request", err)
+
+	return result
+}
+
 func convertToS3Event(m interface{}) events.S3Event {
+	if request, ok := m.(map[string]interface{}); ok && isEventBridgeS3Event(request) {
+		return s3EventFromEventBridge(convertToEventBridgeEvent(m))
+	}
+
 	data, err := json.Marshal(m)
 	handleFatalError("failed to marshal s3 event", err)
 
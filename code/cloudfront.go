@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// cloudFrontS3KeyRegex matches the keys of CloudFront standard log files,
+// <distribution id>.YYYY-MM-DD-HH.<unique id>.gz under an optional prefix.
+var cloudFrontS3KeyRegex = regexp.MustCompile(`(^|/)(?P<distribution>E[A-Z0-9]+)\.\d{4}-\d{2}-\d{2}-\d{2}\.[A-Za-z0-9]+\.gz$`)
+
+const cloudFrontFieldsDirective = "#Fields:"
+
+// cloudFrontRealtimeLogFields are the fields of the real-time log
+// configuration of CloudFront distributions delivering to Kinesis, in order.
+// Kinesis records are not read as real-time logs when it is empty.
+var cloudFrontRealtimeLogFields []string
+
+// parseCloudFrontRealtimeLogFields reads the fields of a real-time log
+// configuration, separated by commas or spaces.
+func parseCloudFrontRealtimeLogFields(config string) ([]string, error) {
+	fields := strings.FieldsFunc(config, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	for _, field := range fields {
+		if !flowLogFieldRegex.MatchString(field) {
+			return nil, fmt.Errorf("invalid cloudfront real-time log field %q", field)
+		}
+	}
+	return fields, nil
+}
+
+// cloudFrontDistributionResourceID maps CloudFront logs to their distribution,
+// or to the fallback resource when the account is unknown or the ARN cannot be
+// built.
+func cloudFrontDistributionResourceID(distributionID string, region string, accountID string, fallback map[string]string) map[string]string {
+	if accountID == "" {
+		return fallback
+	}
+	arn, err := buildGlobalARN("cloudfront", region, accountID, "distribution/"+distributionID)
+	if err != nil {
+		return fallback
+	}
+	return map[string]string{"system.aws.arn": arn}
+}
+
+// parseCloudFrontLogs splits a CloudFront standard log file into one event per
+// request, with the fields named by the #Fields header of the file. S3
+// notifications do not name the account of the distribution, so its ARN is
+// built with the account of the forwarder, and logs go to the log bucket when
+// that account is unknown.
+func parseCloudFrontLogs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	bucketName := request.Records[0].S3.Bucket.Name
+	key := request.Records[0].S3.Object.Key
+	keyMatches := cloudFrontS3KeyRegex.FindStringSubmatch(key)
+	if keyMatches == nil {
+		return lmBatch, fmt.Errorf("failed to parse distribution id for: %s", key)
+	}
+	distributionID := keyMatches[cloudFrontS3KeyRegex.SubexpIndex("distribution")]
+	region := resolveRegion(request.Records[0].AWSRegion)
+	bucketARN, err := buildGlobalARN("s3", region, "", bucketName)
+	if err != nil {
+		return lmBatch, err
+	}
+	resourceID := cloudFrontDistributionResourceID(distributionID, region, resolveAccountID(), map[string]string{"system.aws.arn": bucketARN})
+
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
+	}
+
+	var fields []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, cloudFrontFieldsDirective) {
+			fields = strings.Fields(strings.TrimPrefix(line, cloudFrontFieldsDirective))
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lmEv := LMLog{
+			Log: ingest.Log{
+				Message:    line,
+				ResourceID: resourceID,
+				Timestamp:  request.Records[0].EventTime,
+			},
+		}
+		if record := parseCloudFrontRecord(fields, strings.Split(line, "\t")); record != nil {
+			if timestamp, err := time.Parse("2006-01-02 15:04:05", record["date"]+" "+record["time"]); err == nil {
+				lmEv.Timestamp = timestamp
+			}
+			lmEv.Metadata = record
+		}
+		lmBatch = append(lmBatch, lmEv)
+	}
+	return lmBatch, nil
+}
+
+// parseCloudFrontRecord returns the values of a CloudFront log record by field
+// name, leaving out those without a value. It returns nil when the record does
+// not have the expected number of fields.
+func parseCloudFrontRecord(fields []string, values []string) map[string]string {
+	if len(fields) == 0 || len(values) != len(fields) {
+		return nil
+	}
+
+	record := make(map[string]string)
+	for i, field := range fields {
+		if values[i] != "-" && values[i] != "" {
+			record[field] = values[i]
+		}
+	}
+	return record
+}
+
+// parseCloudFrontRealtimeRecord reads a Kinesis record as a CloudFront
+// real-time log record with the configured fields, mapped to its primary
+// distribution and timestamped with its timestamp field. It reports false
+// when the record does not have the configured number of fields.
+func parseCloudFrontRealtimeRecord(record events.KinesisEventRecord, accountID string) (LMLog, bool) {
+	message := strings.TrimRight(string(record.Kinesis.Data), "\r\n")
+	values := parseCloudFrontRecord(cloudFrontRealtimeLogFields, strings.Split(message, "\t"))
+	if values == nil {
+		return LMLog{}, false
+	}
+
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:    message,
+			ResourceID: accountResourceID(accountID),
+			Timestamp:  record.Kinesis.ApproximateArrivalTimestamp.Time,
+		},
+		Metadata: values,
+	}
+	if distributionID := values["primary-distribution-id"]; distributionID != "" {
+		lmEv.ResourceID = cloudFrontDistributionResourceID(distributionID, resolveRegion(record.AwsRegion), accountID, lmEv.ResourceID)
+	}
+	if seconds, err := strconv.ParseFloat(values["timestamp"], 64); err == nil {
+		lmEv.Timestamp = time.Unix(0, int64(math.Round(seconds*1000))*int64(time.Millisecond))
+	}
+	return lmEv, true
+}
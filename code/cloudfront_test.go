@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCloudFrontLogs(t *testing.T) {
+	awsAccountID = "197152445587"
+	defer func() { awsAccountID = "" }()
+
+	key := "cloudfront/E2QWRUHAPOMQZL.2021-04-26-05.a1b2c3d4.gz"
+	content := "#Version: 1.0\n" +
+		"#Fields: date time x-edge-location sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status cs(Referer)\n" +
+		"2021-04-26\t05:01:34\tNRT57-C1\t392\t192.0.2.100\tGET\td111111abcdef8.cloudfront.net\t/index.html\t200\t-\n" +
+		"2021-04-26\t05:02:12\tNRT57-C1\t388\t192.0.2.101\tGET\td111111abcdef8.cloudfront.net\t/missing.html\t404\thttps://example.com/\n"
+
+	var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+		return gzipString(content)
+	}
+
+	lmEvents, err := parseCloudFrontLogs(s3EventFor("cloudfront-logs", key), getContentsFromS3BucketMock)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lmEvents))
+	assert.Equal(t, "2021-04-26\t05:01:34\tNRT57-C1\t392\t192.0.2.100\tGET\td111111abcdef8.cloudfront.net\t/index.html\t200\t-", lmEvents[0].Message)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:cloudfront::197152445587:distribution/E2QWRUHAPOMQZL"}, lmEvents[0].ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 1, 34, 0, time.UTC), lmEvents[0].Timestamp)
+	assert.Equal(t, map[string]string{
+		"date":            "2021-04-26",
+		"time":            "05:01:34",
+		"x-edge-location": "NRT57-C1",
+		"sc-bytes":        "392",
+		"c-ip":            "192.0.2.100",
+		"cs-method":       "GET",
+		"cs(Host)":        "d111111abcdef8.cloudfront.net",
+		"cs-uri-stem":     "/index.html",
+		"sc-status":       "200",
+	}, lmEvents[0].Metadata)
+	assert.Equal(t, "404", lmEvents[1].Metadata["sc-status"])
+	assert.Equal(t, "https://example.com/", lmEvents[1].Metadata["cs(Referer)"])
+}
+
+func TestParseCloudFrontLogsUniqueID(t *testing.T) {
+	awsAccountID = "111122223333"
+	defer func() { awsAccountID = "" }()
+
+	key := "EMLARXS9EXAMPLE.2019-11-14-20.RT4KCN4SGK9.gz"
+	var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+		return gzipString("#Fields: date time sc-status\n2019-11-14\t20:01:34\t200\n")
+	}
+
+	assert.Equal(t, "cloudfront", s3EventType(s3EventFor("cloudfront-logs", key)))
+
+	lmEvents, err := parseCloudFrontLogs(s3EventFor("cloudfront-logs", key), getContentsFromS3BucketMock)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(lmEvents))
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:cloudfront::111122223333:distribution/EMLARXS9EXAMPLE"}, lmEvents[0].ResourceID)
+}
+
+func TestParseCloudFrontLogsWithoutHeader(t *testing.T) {
+	key := "E2QWRUHAPOMQZL.2021-04-26-05.a1b2c3d4.gz"
+
+	var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+		return gzipString("2021-04-26\t05:01:34\tNRT57-C1\n")
+	}
+
+	lmEvents, err := parseCloudFrontLogs(s3EventFor("cloudfront-logs", key), getContentsFromS3BucketMock)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(lmEvents))
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:s3:::cloudfront-logs"}, lmEvents[0].ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC), lmEvents[0].Timestamp)
+	assert.Nil(t, lmEvents[0].Metadata)
+}
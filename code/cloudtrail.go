@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// cloudTrailS3KeyRegex matches the keys of the log, digest and Insights files
+// CloudTrail delivers to S3, capturing the file type suffix.
+var cloudTrailS3KeyRegex = regexp.MustCompile(`AWSLogs/(o-[a-z0-9]+/)?(?P<account>\d{12})/CloudTrail(?P<type>-Digest|-Insight)?/(?P<region>[a-z0-9-]+)/`)
+
+// cloudTrailRecord holds the fields of a CloudTrail record promoted to log
+// attributes or locating the resource it is about.
+type cloudTrailRecord struct {
+	EventTime       string `json:"eventTime"`
+	EventName       string `json:"eventName"`
+	EventSource     string `json:"eventSource"`
+	AWSRegion       string `json:"awsRegion"`
+	SourceIPAddress string `json:"sourceIPAddress"`
+	ErrorCode       string `json:"errorCode"`
+	UserIdentity    struct {
+		ARN string `json:"arn"`
+	} `json:"userIdentity"`
+	RecipientAccountID string               `json:"recipientAccountId"`
+	Resources          []cloudTrailResource `json:"resources"`
+	RequestParameters  cloudTrailParameters `json:"requestParameters"`
+}
+
+type cloudTrailResource struct {
+	ARN string `json:"ARN"`
+}
+
+func parseCloudTrailLogs(data events.CloudwatchLogsData) []LMLog {
+	lmBatch := make([]LMLog, 0)
+
+	for _, event := range data.LogEvents {
+		lmEv := parseCloudTrailRecord(event.Message, data.Owner, time.Unix(0, event.Timestamp*1000000))
+		lmBatch = append(lmBatch, lmEv)
+	}
+
+	return lmBatch
+
+}
+
+// parseCloudTrailS3Logs splits a CloudTrail log file delivered to S3 into one
+// event per record.
+func parseCloudTrailS3Logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	return parseCloudTrailLogFile(request, getContentsFromS3Bucket, parseCloudTrailRecord)
+}
+
+// parseCloudTrailInsightS3Logs splits a CloudTrail Insights file delivered to
+// S3 into one event per insight record.
+func parseCloudTrailInsightS3Logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	return parseCloudTrailLogFile(request, getContentsFromS3Bucket, parseCloudTrailInsightRecord)
+}
+
+// cloudTrailRecordParser returns the log of a record of a CloudTrail file
+// owned by the given account and delivered at the given time.
+type cloudTrailRecordParser func(message string, owner string, deliveryTime time.Time) LMLog
+
+func parseCloudTrailLogFile(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket, parseRecord cloudTrailRecordParser) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	bucketName := request.Records[0].S3.Bucket.Name
+	key := request.Records[0].S3.Object.Key
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
+	}
+
+	var owner string
+	if keyMatches := cloudTrailS3KeyRegex.FindStringSubmatch(key); keyMatches != nil {
+		owner = keyMatches[cloudTrailS3KeyRegex.SubexpIndex("account")]
+	}
+
+	var logFile struct {
+		Records []json.RawMessage `json:"Records"`
+	}
+	err = json.Unmarshal([]byte(content), &logFile)
+	if err != nil {
+		return lmBatch, fmt.Errorf("failed to parse cloudtrail log file %s: %s", key, err)
+	}
+
+	for _, rawRecord := range logFile.Records {
+		lmEv := parseRecord(string(rawRecord), owner, request.Records[0].EventTime)
+		lmBatch = append(lmBatch, lmEv)
+	}
+	return lmBatch, nil
+}
+
+// parseCloudTrailRecord returns the log of a CloudTrail record, timestamped
+// with its eventTime or, failing that, the time it was delivered. Records that
+// cannot be decoded are forwarded as-is against the account.
+func parseCloudTrailRecord(message string, owner string, deliveryTime time.Time) LMLog {
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:   message,
+			Timestamp: deliveryTime,
+		},
+	}
+
+	var record cloudTrailRecord
+	if err := json.Unmarshal([]byte(message), &record); err != nil {
+		fmt.Printf("WARN failed to parse cloudtrail record %s\n", err)
+		lmEv.ResourceID = accountResourceID(resolveAccountID(owner))
+		return lmEv
+	}
+
+	if eventTime, err := time.Parse(time.RFC3339, record.EventTime); err == nil {
+		lmEv.Timestamp = eventTime
+	}
+	lmEv.ResourceID = cloudTrailResourceID(record, owner)
+	lmEv.Metadata = cloudTrailAttributes(record)
+	return lmEv
+}
+
+// cloudTrailAttributes returns the fields of a CloudTrail record promoted to
+// log attributes.
+func cloudTrailAttributes(record cloudTrailRecord) map[string]string {
+	fields := map[string]string{
+		"eventName":        record.EventName,
+		"eventSource":      record.EventSource,
+		"userIdentity.arn": record.UserIdentity.ARN,
+		"sourceIPAddress":  record.SourceIPAddress,
+		"errorCode":        record.ErrorCode,
+		"awsRegion":        record.AWSRegion,
+	}
+	return attributes(fields)
+}
+
+// cloudTrailResourceID returns the resource a CloudTrail record is about, or
+// the account the record was delivered to. The resources listed by CloudTrail
+// take precedence over those named in the request parameters.
+func cloudTrailResourceID(record cloudTrailRecord, owner string) map[string]string {
+	region := resolveRegion(record.AWSRegion)
+	accountID := resolveAccountID(record.RecipientAccountID, owner)
+
+	for _, resource := range record.Resources {
+		if validateARN(resource.ARN) == nil {
+			return map[string]string{"system.aws.arn": resource.ARN}
+		}
+	}
+
+	params := record.RequestParameters
+	if arn := params.str("resourceArn", "resourceARN"); validateARN(arn) == nil {
+		return map[string]string{"system.aws.arn": arn}
+	}
+
+	if resolve, ok := cloudTrailResourceResolvers[record.EventSource]; ok {
+		arn, err := resolve(params, region, accountID)
+		if err == nil && arn != "" && validateARN(arn) == nil {
+			return map[string]string{"system.aws.arn": arn}
+		}
+	}
+
+	return accountResourceID(accountID)
+}
+
+// cloudTrailParameters holds the request parameters of a CloudTrail record.
+type cloudTrailParameters map[string]interface{}
+
+// str returns the first of the given parameters holding a non-empty string,
+// or the first element of one holding a list of strings.
+func (p cloudTrailParameters) str(keys ...string) string {
+	for _, key := range keys {
+		switch value := p[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case []interface{}:
+			if len(value) > 0 {
+				if first, ok := value[0].(string); ok && first != "" {
+					return first
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// items returns the string values of key in the items of a set parameter such
+// as instancesSet.
+func (p cloudTrailParameters) items(set string, key string) []string {
+	values := make([]string, 0)
+	setValue, _ := p[set].(map[string]interface{})
+	items, _ := setValue["items"].([]interface{})
+	for _, item := range items {
+		itemValue, _ := item.(map[string]interface{})
+		if value, ok := itemValue[key].(string); ok && value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+type cloudTrailResourceResolver func(params cloudTrailParameters, region string, accountID string) (string, error)
+
+// cloudTrailResourceResolvers build the ARN of the resource named in the
+// request parameters of a record, keyed by event source.
+var cloudTrailResourceResolvers = map[string]cloudTrailResourceResolver{
+	"ec2.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		instanceID := params.str("instanceId")
+		if instances := params.items("instancesSet", "instanceId"); instanceID == "" && len(instances) > 0 {
+			instanceID = instances[0]
+		}
+		return resourceARN("ec2", region, accountID, "instance/", instanceID)
+	},
+	"lambda.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		return resourceARN("lambda", region, accountID, "function:", params.str("functionName"))
+	},
+	"rds.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		if instanceID := params.str("dBInstanceIdentifier"); instanceID != "" {
+			return resourceARN("rds", region, accountID, "db:", instanceID)
+		}
+		return resourceARN("rds", region, accountID, "cluster:", params.str("dBClusterIdentifier"))
+	},
+	"dynamodb.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		return resourceARN("dynamodb", region, accountID, "table/", params.str("tableName"))
+	},
+	"sqs.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		// Queue URLs are https://sqs.<region>.amazonaws.com/<account>/<queue>.
+		queueURL, err := url.Parse(params.str("queueUrl"))
+		if err != nil {
+			return "", err
+		}
+		path := strings.Split(strings.Trim(queueURL.Path, "/"), "/")
+		if len(path) != 2 {
+			return "", nil
+		}
+		return buildARN("sqs", region, path[0], path[1])
+	},
+	"sns.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		return params.str("topicArn", "targetArn"), nil
+	},
+	"iam.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		if roleName := params.str("roleName"); roleName != "" {
+			return buildGlobalARN("iam", region, accountID, "role/"+roleName)
+		}
+		if userName := params.str("userName"); userName != "" {
+			return buildGlobalARN("iam", region, accountID, "user/"+userName)
+		}
+		return params.str("policyArn"), nil
+	},
+	"kms.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		keyID := params.str("keyId")
+		if strings.HasPrefix(keyID, "alias/") {
+			return buildARN("kms", region, accountID, keyID)
+		}
+		return resourceARN("kms", region, accountID, "key/", keyID)
+	},
+	"elasticloadbalancing.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		if arn := params.str("loadBalancerArn", "loadBalancerArns"); arn != "" {
+			return arn, nil
+		}
+		return resourceARN("elasticloadbalancing", region, accountID, "loadbalancer/", params.str("loadBalancerName", "loadBalancerNames"))
+	},
+	"firehose.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		return resourceARN("firehose", region, accountID, "deliverystream/", params.str("deliveryStreamARN", "deliveryStreamName"))
+	},
+	"kinesis.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		return resourceARN("kinesis", region, accountID, "stream/", params.str("streamARN", "streamName"))
+	},
+	"ecs.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		return resourceARN("ecs", region, accountID, "cluster/", params.str("cluster"))
+	},
+	"s3.amazonaws.com": func(params cloudTrailParameters, region string, accountID string) (string, error) {
+		if bucketName := params.str("bucketName"); bucketName != "" {
+			return buildGlobalARN("s3", region, "", bucketName)
+		}
+		return "", nil
+	},
+}
+
+// resourceARN builds the ARN of a regional resource from its name, keeping
+// names that already are ARNs. An empty name resolves to no resource.
+func resourceARN(service string, region string, accountID string, resourceType string, name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "arn:") {
+		return name, nil
+	}
+	return buildARN(service, region, accountID, resourceType+name)
+}
+
+// cloudTrailInsightRecord holds the fields of a CloudTrail Insights record
+// promoted to log attributes.
+type cloudTrailInsightRecord struct {
+	EventTime          string `json:"eventTime"`
+	AWSRegion          string `json:"awsRegion"`
+	SharedEventID      string `json:"sharedEventID"`
+	RecipientAccountID string `json:"recipientAccountId"`
+	InsightDetails     struct {
+		State          string `json:"state"`
+		EventSource    string `json:"eventSource"`
+		EventName      string `json:"eventName"`
+		InsightType    string `json:"insightType"`
+		ErrorCode      string `json:"errorCode"`
+		InsightContext struct {
+			Statistics struct {
+				Baseline struct {
+					Average *float64 `json:"average"`
+				} `json:"baseline"`
+				Insight struct {
+					Average *float64 `json:"average"`
+				} `json:"insight"`
+			} `json:"statistics"`
+		} `json:"insightContext"`
+	} `json:"insightDetails"`
+}
+
+// parseCloudTrailInsightRecord returns the log of a CloudTrail Insights
+// record, attached to the account it was raised in.
+func parseCloudTrailInsightRecord(message string, owner string, deliveryTime time.Time) LMLog {
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:   message,
+			Timestamp: deliveryTime,
+		},
+	}
+
+	var record cloudTrailInsightRecord
+	if err := json.Unmarshal([]byte(message), &record); err != nil {
+		fmt.Printf("WARN failed to parse cloudtrail insight record %s\n", err)
+		lmEv.ResourceID = accountResourceID(resolveAccountID(owner))
+		return lmEv
+	}
+
+	if eventTime, err := time.Parse(time.RFC3339, record.EventTime); err == nil {
+		lmEv.Timestamp = eventTime
+	}
+	lmEv.ResourceID = accountResourceID(resolveAccountID(record.RecipientAccountID, owner))
+
+	details := record.InsightDetails
+	statistics := details.InsightContext.Statistics
+	fields := map[string]string{
+		"sharedEventID":              record.SharedEventID,
+		"awsRegion":                  record.AWSRegion,
+		"insightDetails.state":       details.State,
+		"insightDetails.eventSource": details.EventSource,
+		"insightDetails.eventName":   details.EventName,
+		"insightDetails.insightType": details.InsightType,
+		"insightDetails.errorCode":   details.ErrorCode,
+	}
+	if statistics.Baseline.Average != nil {
+		fields["insightDetails.insightContext.statistics.baseline.average"] = strconv.FormatFloat(*statistics.Baseline.Average, 'f', -1, 64)
+	}
+	if statistics.Insight.Average != nil {
+		fields["insightDetails.insightContext.statistics.insight.average"] = strconv.FormatFloat(*statistics.Insight.Average, 'f', -1, 64)
+	}
+
+	lmEv.Metadata = attributes(fields)
+	return lmEv
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipString(content string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write([]byte(content))
+	w.Close()
+	return buf.String()
+}
+
+func s3EventFor(bucket string, key string) events.S3Event {
+	eventTime, _ := time.Parse(time.RFC3339, "2021-04-26T05:50:00Z")
+	return events.S3Event{
+		Records: []events.S3EventRecord{{
+			AWSRegion: "ap-northeast-1",
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: bucket},
+				Object: events.S3Object{Key: key},
+			},
+			EventTime: eventTime,
+		}},
+	}
+}
+
+func TestParseCloudTrailS3Logs(t *testing.T) {
+	key := "AWSLogs/197152445587/CloudTrail/ap-northeast-1/2021/04/26/197152445587_CloudTrail_ap-northeast-1_20210426T0550Z_xQQbx1hRL6YkH4mF.json.gz"
+	firehoseRecord := `{"eventVersion":"1.08","eventTime":"2021-04-26T05:45:15Z","eventSource":"firehose.amazonaws.com","eventName":"DescribeDeliveryStream","awsRegion":"ap-northeast-1","requestParameters":{"deliveryStreamName":"firehosedelievery"},"recipientAccountId":"197152445587"}`
+	consoleRecord := `{"eventVersion":"1.08","eventTime":"2021-04-26T05:46:02Z","eventSource":"signin.amazonaws.com","eventName":"ConsoleLogin","awsRegion":"us-east-1","recipientAccountId":"197152445587"}`
+	content := `{"Records":[` + firehoseRecord + `,` + consoleRecord + `]}`
+
+	var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+		assert.Equal(t, "cloudtrail-bucket", bucket)
+		assert.Equal(t, key, fileName)
+		return gzipString(content)
+	}
+
+	lmEvents, err := parseCloudTrailS3Logs(s3EventFor("cloudtrail-bucket", key), getContentsFromS3BucketMock)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lmEvents))
+	assert.Equal(t, ingest.Log{
+		Message:    firehoseRecord,
+		Timestamp:  time.Date(2021, time.April, 26, 5, 45, 15, 0, time.UTC),
+		ResourceID: map[string]string{"system.aws.arn": "arn:aws:firehose:ap-northeast-1:197152445587:deliverystream/firehosedelievery"},
+	}, lmEvents[0].Log)
+	assert.Equal(t, ingest.Log{
+		Message:    consoleRecord,
+		Timestamp:  time.Date(2021, time.April, 26, 5, 46, 2, 0, time.UTC),
+		ResourceID: accountResourceID("197152445587"),
+	}, lmEvents[1].Log)
+
+	t.Run("organization trail", func(t *testing.T) {
+		orgKey := "AWSLogs/o-a1b2c3d4e5/222222222222/CloudTrail/us-east-1/2021/04/26/222222222222_CloudTrail_us-east-1_20210426T0550Z_a.json.gz"
+		var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+			return `{"Records":[{"eventTime":"2021-04-26T05:46:02Z","eventSource":"signin.amazonaws.com","eventName":"ConsoleLogin"}]}`
+		}
+
+		lmEvents, err := parseCloudTrailS3Logs(s3EventFor("cloudtrail-bucket", orgKey), getContentsFromS3BucketMock)
+
+		assert.NoError(t, err)
+		assert.Equal(t, accountResourceID("222222222222"), lmEvents[0].ResourceID)
+	})
+
+	t.Run("invalid log file", func(t *testing.T) {
+		var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+			return "not a cloudtrail file"
+		}
+
+		_, err := parseCloudTrailS3Logs(s3EventFor("cloudtrail-bucket", key), getContentsFromS3BucketMock)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestCloudTrailResourceID(t *testing.T) {
+	recordFor := func(eventSource string, params string) string {
+		return `{"eventSource":"` + eventSource + `","awsRegion":"us-west-1","recipientAccountId":"197152445587","requestParameters":` + params + `}`
+	}
+
+	resources := []struct {
+		message string
+		arn     string
+	}{
+		{recordFor("ec2.amazonaws.com", `{"instanceId":"i-067b718e521cdf437"}`), "arn:aws:ec2:us-west-1:197152445587:instance/i-067b718e521cdf437"},
+		{recordFor("ec2.amazonaws.com", `{"instancesSet":{"items":[{"instanceId":"i-0d345eec77c8a08b1"}]}}`), "arn:aws:ec2:us-west-1:197152445587:instance/i-0d345eec77c8a08b1"},
+		{recordFor("lambda.amazonaws.com", `{"functionName":"observatory-worker"}`), "arn:aws:lambda:us-west-1:197152445587:function:observatory-worker"},
+		{recordFor("lambda.amazonaws.com", `{"functionName":"arn:aws:lambda:us-west-1:197152445587:function:observatory-worker"}`), "arn:aws:lambda:us-west-1:197152445587:function:observatory-worker"},
+		{recordFor("rds.amazonaws.com", `{"dBInstanceIdentifier":"database-1"}`), "arn:aws:rds:us-west-1:197152445587:db:database-1"},
+		{recordFor("rds.amazonaws.com", `{"dBClusterIdentifier":"orders"}`), "arn:aws:rds:us-west-1:197152445587:cluster:orders"},
+		{recordFor("dynamodb.amazonaws.com", `{"tableName":"sessions"}`), "arn:aws:dynamodb:us-west-1:197152445587:table/sessions"},
+		{recordFor("sqs.amazonaws.com", `{"queueUrl":"https://sqs.us-west-1.amazonaws.com/222222222222/orders"}`), "arn:aws:sqs:us-west-1:222222222222:orders"},
+		{recordFor("sns.amazonaws.com", `{"topicArn":"arn:aws:sns:us-west-1:197152445587:alerts"}`), "arn:aws:sns:us-west-1:197152445587:alerts"},
+		{recordFor("iam.amazonaws.com", `{"roleName":"LogicMonitor","policyArn":"arn:aws:iam::aws:policy/ReadOnlyAccess"}`), "arn:aws:iam::197152445587:role/LogicMonitor"},
+		{recordFor("iam.amazonaws.com", `{"userName":"alice"}`), "arn:aws:iam::197152445587:user/alice"},
+		{recordFor("iam.amazonaws.com", `{"policyArn":"arn:aws:iam::aws:policy/ReadOnlyAccess"}`), "arn:aws:iam::aws:policy/ReadOnlyAccess"},
+		{recordFor("kms.amazonaws.com", `{"keyId":"1234abcd-12ab-34cd-56ef-1234567890ab"}`), "arn:aws:kms:us-west-1:197152445587:key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+		{recordFor("kms.amazonaws.com", `{"keyId":"alias/logs"}`), "arn:aws:kms:us-west-1:197152445587:alias/logs"},
+		{recordFor("elasticloadbalancing.amazonaws.com", `{"loadBalancerArns":["arn:aws:elasticloadbalancing:us-west-1:197152445587:loadbalancer/app/test/50dc6c495c0c9188"]}`), "arn:aws:elasticloadbalancing:us-west-1:197152445587:loadbalancer/app/test/50dc6c495c0c9188"},
+		{recordFor("elasticloadbalancing.amazonaws.com", `{"loadBalancerName":"test"}`), "arn:aws:elasticloadbalancing:us-west-1:197152445587:loadbalancer/test"},
+		{recordFor("s3.amazonaws.com", `{"bucketName":"LogBucket"}`), "arn:aws:s3:::LogBucket"},
+		{recordFor("ecs.amazonaws.com", `{"resourceArn":"arn:aws:ecs:us-west-1:197152445587:cluster/CVTestCluster"}`), "arn:aws:ecs:us-west-1:197152445587:cluster/CVTestCluster"},
+	}
+	for _, r := range resources {
+		assert.Equal(t, map[string]string{"system.aws.arn": r.arn}, parseCloudTrailRecord(r.message, "", time.Time{}).ResourceID, r.message)
+	}
+
+	t.Run("resources take precedence", func(t *testing.T) {
+		message := `{"eventSource":"kms.amazonaws.com","awsRegion":"us-west-1","requestParameters":{"keyId":"alias/logs"},"resources":[{"accountId":"197152445587","type":"AWS::KMS::Key","ARN":"arn:aws:kms:us-west-1:197152445587:key/1234abcd-12ab-34cd-56ef-1234567890ab"}]}`
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:kms:us-west-1:197152445587:key/1234abcd-12ab-34cd-56ef-1234567890ab"}, parseCloudTrailRecord(message, "197152445587", time.Time{}).ResourceID)
+	})
+
+	t.Run("unresolved records fall back to the account", func(t *testing.T) {
+		for _, message := range []string{
+			recordFor("signin.amazonaws.com", `{}`),
+			recordFor("ec2.amazonaws.com", `{"filterSet":{}}`),
+			recordFor("sns.amazonaws.com", `{"topicArn":"alerts"}`),
+			recordFor("lambda.amazonaws.com", `null`),
+		} {
+			assert.Equal(t, accountResourceID("197152445587"), parseCloudTrailRecord(message, "", time.Time{}).ResourceID, message)
+		}
+	})
+}
+
+func TestParseCloudTrailRecord(t *testing.T) {
+	deliveryTime := time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC)
+
+	t.Run("formatted record", func(t *testing.T) {
+		message := "{\n  \"eventTime\": \"2021-04-26T05:45:15Z\",\n  \"eventSource\": \"kinesis.amazonaws.com\",\n  \"eventName\": \"DescribeStream\",\n  \"awsRegion\": \"eu-north-1\",\n  \"requestParameters\": {\"streamName\": \"orders\"}\n}"
+		lmEv := parseCloudTrailRecord(message, "222222222222", deliveryTime)
+
+		assert.Equal(t, time.Date(2021, time.April, 26, 5, 45, 15, 0, time.UTC), lmEv.Timestamp)
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:kinesis:eu-north-1:222222222222:stream/orders"}, lmEv.ResourceID)
+		assert.Equal(t, map[string]string{"eventName": "DescribeStream", "eventSource": "kinesis.amazonaws.com", "awsRegion": "eu-north-1"}, lmEv.Metadata)
+	})
+
+	t.Run("malformed record", func(t *testing.T) {
+		for _, message := range []string{"not a cloudtrail record", `{"eventSource": 42}`, `{"eventSource":`} {
+			lmEv := parseCloudTrailRecord(message, "222222222222", deliveryTime)
+
+			assert.Equal(t, message, lmEv.Message)
+			assert.Equal(t, deliveryTime, lmEv.Timestamp)
+			assert.Equal(t, accountResourceID("222222222222"), lmEv.ResourceID)
+			assert.Nil(t, lmEv.Metadata)
+		}
+	})
+}
+
+func TestParseCloudTrailInsightS3Logs(t *testing.T) {
+	key := "AWSLogs/197152445587/CloudTrail-Insight/us-east-1/2021/04/26/197152445587_CloudTrail-Insight_us-east-1_20210426T0550Z_xQQbx1hRL6YkH4mF.json.gz"
+	insightRecord := `{"eventVersion":"1.07","eventTime":"2021-04-26T05:41:00Z","awsRegion":"us-east-1","eventID":"a9edc959-9488-4790-be0f-05d60e56b547","eventType":"AwsCloudTrailInsight","recipientAccountId":"197152445587","sharedEventID":"8f8c7e8a-9a4a-4d8e-a9f5-9a1c3e0f4d21","insightDetails":{"state":"Start","eventSource":"ssm.amazonaws.com","eventName":"UpdateInstanceAssociationStatus","insightType":"ApiCallRateInsight","insightContext":{"statistics":{"baseline":{"average":85.4202380952},"insight":{"average":664},"insightDuration":1}}},"eventCategory":"Insight"}`
+
+	var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+		return gzipString(`{"Records":[` + insightRecord + `]}`)
+	}
+
+	lmEvents, err := parseCloudTrailInsightS3Logs(s3EventFor("cloudtrail-bucket", key), getContentsFromS3BucketMock)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ingest.Log{
+		Message:    insightRecord,
+		Timestamp:  time.Date(2021, time.April, 26, 5, 41, 0, 0, time.UTC),
+		ResourceID: accountResourceID("197152445587"),
+	}, lmEvents[0].Log)
+	assert.Equal(t, map[string]string{
+		"sharedEventID":              "8f8c7e8a-9a4a-4d8e-a9f5-9a1c3e0f4d21",
+		"awsRegion":                  "us-east-1",
+		"insightDetails.state":       "Start",
+		"insightDetails.eventSource": "ssm.amazonaws.com",
+		"insightDetails.eventName":   "UpdateInstanceAssociationStatus",
+		"insightDetails.insightType": "ApiCallRateInsight",
+		"insightDetails.insightContext.statistics.baseline.average": "85.4202380952",
+		"insightDetails.insightContext.statistics.insight.average":  "664",
+	}, lmEvents[0].Metadata)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+const cloudTrailDigestSignatureAlgorithm = "SHA256withRSA"
+
+// cloudTrailDigestValidation enables the signature validation of CloudTrail
+// digest files, which are skipped otherwise.
+var cloudTrailDigestValidation bool
+
+// cloudTrailDigest holds the fields of a CloudTrail digest file needed to
+// validate its signature.
+type cloudTrailDigest struct {
+	AWSAccountID               string            `json:"awsAccountId"`
+	DigestStartTime            string            `json:"digestStartTime"`
+	DigestEndTime              string            `json:"digestEndTime"`
+	DigestS3Bucket             string            `json:"digestS3Bucket"`
+	DigestS3Object             string            `json:"digestS3Object"`
+	DigestPublicKeyFingerprint string            `json:"digestPublicKeyFingerprint"`
+	PreviousDigestSignature    *string           `json:"previousDigestSignature"`
+	LogFiles                   []json.RawMessage `json:"logFiles"`
+}
+
+// parseCloudTrailDigest validates the signature of a CloudTrail digest file
+// and returns a single event reporting the result.
+func parseCloudTrailDigest(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket, getS3ObjectMetadata GetS3ObjectMetadata, getCloudTrailPublicKeys GetCloudTrailPublicKeys) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	bucketName := request.Records[0].S3.Bucket.Name
+	key := request.Records[0].S3.Object.Key
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
+	}
+
+	var digest cloudTrailDigest
+	err = json.Unmarshal([]byte(content), &digest)
+	if err != nil {
+		return lmBatch, fmt.Errorf("failed to parse cloudtrail digest file %s: %s", key, err)
+	}
+
+	startTime, err := time.Parse(time.RFC3339, digest.DigestStartTime)
+	if err != nil {
+		return lmBatch, fmt.Errorf("invalid digestStartTime in %s: %s", key, err)
+	}
+	endTime, err := time.Parse(time.RFC3339, digest.DigestEndTime)
+	if err != nil {
+		return lmBatch, fmt.Errorf("invalid digestEndTime in %s: %s", key, err)
+	}
+
+	metadata, err := getS3ObjectMetadata(bucketName, key)
+	if err != nil {
+		return lmBatch, fmt.Errorf("failed to get metadata of cloudtrail digest file %s: %s", key, err)
+	}
+
+	var owner, region string
+	if keyMatches := cloudTrailS3KeyRegex.FindStringSubmatch(key); keyMatches != nil {
+		owner = keyMatches[cloudTrailS3KeyRegex.SubexpIndex("account")]
+		region = keyMatches[cloudTrailS3KeyRegex.SubexpIndex("region")]
+	}
+	region = resolveRegion(region, request.Records[0].AWSRegion)
+
+	publicKeys, err := getCloudTrailPublicKeys(region, startTime, endTime)
+	if err != nil {
+		return lmBatch, fmt.Errorf("failed to get cloudtrail public keys in %s: %s", region, err)
+	}
+
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:    content,
+			ResourceID: accountResourceID(resolveAccountID(digest.AWSAccountID, owner)),
+			Timestamp:  endTime,
+		},
+		Metadata: map[string]string{
+			"digestS3Object":             key,
+			"digestPublicKeyFingerprint": digest.DigestPublicKeyFingerprint,
+			"digest.logFiles":            strconv.Itoa(len(digest.LogFiles)),
+			"digest.signatureVerified":   "true",
+		},
+	}
+
+	err = verifyCloudTrailDigest(digest, content, metadata, publicKeys)
+	if err != nil {
+		fmt.Printf("WARN cloudtrail digest file %s failed validation: %s\n", key, err)
+		lmEv.Metadata["digest.signatureVerified"] = "false"
+		lmEv.Metadata["digest.validationError"] = err.Error()
+	}
+
+	lmBatch = append(lmBatch, lmEv)
+	return lmBatch, nil
+}
+
+// verifyCloudTrailDigest checks the signature stored in the object metadata of
+// a digest file against the data signing string CloudTrail signed.
+func verifyCloudTrailDigest(digest cloudTrailDigest, content string, metadata map[string]string, publicKeys map[string][]byte) error {
+	if algorithm := metadata["signature-algorithm"]; algorithm != cloudTrailDigestSignatureAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+
+	signature, err := hex.DecodeString(metadata["signature"])
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("missing or invalid signature")
+	}
+
+	publicKeyValue, ok := publicKeys[digest.DigestPublicKeyFingerprint]
+	if !ok {
+		return fmt.Errorf("no public key with fingerprint %s", digest.DigestPublicKeyFingerprint)
+	}
+	publicKey, err := x509.ParsePKCS1PublicKey(publicKeyValue)
+	if err != nil {
+		return fmt.Errorf("invalid public key %s: %s", digest.DigestPublicKeyFingerprint, err)
+	}
+
+	previousSignature := "null"
+	if digest.PreviousDigestSignature != nil {
+		previousSignature = *digest.PreviousDigestSignature
+	}
+
+	contentHash := sha256.Sum256([]byte(content))
+	signingString := fmt.Sprintf("%s\n%s/%s\n%s\n%s",
+		digest.DigestEndTime,
+		digest.DigestS3Bucket,
+		digest.DigestS3Object,
+		hex.EncodeToString(contentHash[:]),
+		previousSignature,
+	)
+
+	signingHash := sha256.Sum256([]byte(signingString))
+	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, signingHash[:], signature)
+	if err != nil {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
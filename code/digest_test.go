@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCloudTrailDigest(t *testing.T) {
+	key := "AWSLogs/197152445587/CloudTrail-Digest/us-east-1/2021/04/26/197152445587_CloudTrail-Digest_us-east-1_trail_us-east-1_20210426T055000Z.json.gz"
+	content := `{"awsAccountId":"197152445587","digestStartTime":"2021-04-26T04:50:00Z","digestEndTime":"2021-04-26T05:50:00Z","digestS3Bucket":"cloudtrail-bucket","digestS3Object":"` + key + `","digestPublicKeyFingerprint":"67b9fa73676d86966b449dd677850753","digestSignatureAlgorithm":"SHA256withRSA","previousDigestSignature":null,"logFiles":[{"s3Bucket":"cloudtrail-bucket","s3Object":"AWSLogs/197152445587/CloudTrail/us-east-1/2021/04/26/a.json.gz","hashValue":"9bb6196fc6b84d6f075a56548feca262bd99ba3c2de41b618e5b6e22c1fc71f6","hashAlgorithm":"SHA-256"}]}`
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	contentHash := sha256.Sum256([]byte(content))
+	signingHash := sha256.Sum256([]byte("2021-04-26T05:50:00Z\ncloudtrail-bucket/" + key + "\n" + hex.EncodeToString(contentHash[:]) + "\nnull"))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, signingHash[:])
+	assert.NoError(t, err)
+
+	var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+		return gzipString(content)
+	}
+	metadata := map[string]string{"signature": hex.EncodeToString(signature), "signature-algorithm": "SHA256withRSA"}
+	var getS3ObjectMetadataMock = func(bucket string, fileName string) (map[string]string, error) {
+		assert.Equal(t, "cloudtrail-bucket", bucket)
+		assert.Equal(t, key, fileName)
+		return metadata, nil
+	}
+	var getCloudTrailPublicKeysMock = func(region string, startTime time.Time, endTime time.Time) (map[string][]byte, error) {
+		assert.Equal(t, "us-east-1", region)
+		assert.Equal(t, time.Date(2021, time.April, 26, 4, 50, 0, 0, time.UTC), startTime)
+		assert.Equal(t, time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC), endTime)
+		return map[string][]byte{"67b9fa73676d86966b449dd677850753": x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)}, nil
+	}
+
+	t.Run("valid signature", func(t *testing.T) {
+		lmEvents, err := parseCloudTrailDigest(s3EventFor("cloudtrail-bucket", key), getContentsFromS3BucketMock, getS3ObjectMetadataMock, getCloudTrailPublicKeysMock)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(lmEvents))
+		assert.Equal(t, content, lmEvents[0].Message)
+		assert.Equal(t, time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC), lmEvents[0].Timestamp)
+		assert.Equal(t, accountResourceID("197152445587"), lmEvents[0].ResourceID)
+		assert.Equal(t, map[string]string{
+			"digestS3Object":             key,
+			"digestPublicKeyFingerprint": "67b9fa73676d86966b449dd677850753",
+			"digest.logFiles":            "1",
+			"digest.signatureVerified":   "true",
+		}, lmEvents[0].Metadata)
+	})
+
+	t.Run("tampered digest", func(t *testing.T) {
+		var getTamperedContentsMock = func(bucket string, fileName string) string {
+			return content[:len(content)-2] + `,{"s3Bucket":"cloudtrail-bucket"}]}`
+		}
+
+		lmEvents, err := parseCloudTrailDigest(s3EventFor("cloudtrail-bucket", key), getTamperedContentsMock, getS3ObjectMetadataMock, getCloudTrailPublicKeysMock)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "false", lmEvents[0].Metadata["digest.signatureVerified"])
+		assert.Equal(t, "signature does not match", lmEvents[0].Metadata["digest.validationError"])
+	})
+
+	t.Run("unknown public key", func(t *testing.T) {
+		var getOtherPublicKeysMock = func(region string, startTime time.Time, endTime time.Time) (map[string][]byte, error) {
+			return map[string][]byte{}, nil
+		}
+
+		lmEvents, err := parseCloudTrailDigest(s3EventFor("cloudtrail-bucket", key), getContentsFromS3BucketMock, getS3ObjectMetadataMock, getOtherPublicKeysMock)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "false", lmEvents[0].Metadata["digest.signatureVerified"])
+	})
+
+	t.Run("public key lookup failure", func(t *testing.T) {
+		var getPublicKeysFailure = func(region string, startTime time.Time, endTime time.Time) (map[string][]byte, error) {
+			return nil, fmt.Errorf("AccessDeniedException")
+		}
+
+		lmEvents, err := parseCloudTrailDigest(s3EventFor("cloudtrail-bucket", key), getContentsFromS3BucketMock, getS3ObjectMetadataMock, getPublicKeysFailure)
+
+		assert.Error(t, err)
+		assert.Empty(t, lmEvents)
+	})
+}
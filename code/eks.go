@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var eksClusterLogGroupRegex = regexp.MustCompile(`/aws/eks/(.*)/cluster`)
+
+// eksLogStreamTypes maps the log stream prefixes of the EKS control plane to
+// the log type they carry. Longer prefixes come first.
+var eksLogStreamTypes = []struct {
+	prefix  string
+	logType string
+}{
+	{"kube-apiserver-audit-", "audit"},
+	{"kube-apiserver-", "kube-apiserver"},
+	{"authenticator-", "authenticator"},
+	{"kube-controller-manager-", "controllerManager"},
+	{"cloud-controller-manager-", "controllerManager"},
+	{"kube-scheduler-", "scheduler"},
+}
+
+type eksAuditEvent struct {
+	AuditID    string   `json:"auditID"`
+	Stage      string   `json:"stage"`
+	Verb       string   `json:"verb"`
+	RequestURI string   `json:"requestURI"`
+	SourceIPs  []string `json:"sourceIPs"`
+	UserAgent  string   `json:"userAgent"`
+	User       struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	ObjectRef struct {
+		Resource  string `json:"resource"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"objectRef"`
+	ResponseStatus struct {
+		Code int `json:"code"`
+	} `json:"responseStatus"`
+}
+
+// parseEKSControlPlaneLog returns the attributes of an EKS control plane log
+// event: its log type and, for audit logs, the main fields of the audit event.
+func parseEKSControlPlaneLog(logStream string, message string) map[string]string {
+	metadata := make(map[string]string)
+	for _, streamType := range eksLogStreamTypes {
+		if strings.HasPrefix(logStream, streamType.prefix) {
+			metadata["eks.logType"] = streamType.logType
+			break
+		}
+	}
+
+	if metadata["eks.logType"] != "audit" {
+		return metadata
+	}
+
+	var auditEvent eksAuditEvent
+	if err := json.Unmarshal([]byte(message), &auditEvent); err != nil {
+		return metadata
+	}
+
+	fields := map[string]string{
+		"eks.logType":         metadata["eks.logType"],
+		"auditID":             auditEvent.AuditID,
+		"stage":               auditEvent.Stage,
+		"verb":                auditEvent.Verb,
+		"requestURI":          auditEvent.RequestURI,
+		"sourceIPs":           strings.Join(auditEvent.SourceIPs, ","),
+		"userAgent":           auditEvent.UserAgent,
+		"user.username":       auditEvent.User.Username,
+		"objectRef.resource":  auditEvent.ObjectRef.Resource,
+		"objectRef.namespace": auditEvent.ObjectRef.Namespace,
+		"objectRef.name":      auditEvent.ObjectRef.Name,
+	}
+	if auditEvent.ResponseStatus.Code != 0 {
+		fields["responseStatus.code"] = strconv.Itoa(auditEvent.ResponseStatus.Code)
+	}
+
+	return attributes(fields)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEKSControlPlaneLog(t *testing.T) {
+
+	t.Run("log stream types", func(t *testing.T) {
+		logTypes := map[string]string{
+			"kube-apiserver-4ea105b859276d339937212f26506657":           "kube-apiserver",
+			"kube-apiserver-audit-4ea105b859276d339937212f26506657":     "audit",
+			"authenticator-4ea105b859276d339937212f26506657":            "authenticator",
+			"kube-controller-manager-4ea105b859276d339937212f26506657":  "controllerManager",
+			"cloud-controller-manager-4ea105b859276d339937212f26506657": "controllerManager",
+			"kube-scheduler-4ea105b859276d339937212f26506657":           "scheduler",
+		}
+		for logStream, logType := range logTypes {
+			assert.Equal(t, map[string]string{"eks.logType": logType}, parseEKSControlPlaneLog(logStream, "I0825 14:06:08.442600 started"))
+		}
+		assert.Empty(t, parseEKSControlPlaneLog("custom-stream", "started"))
+	})
+
+	t.Run("audit event", func(t *testing.T) {
+		message := `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","auditID":"e3c9a0c5-1f3a-4b0c-9f3e-1c2d3e4f5a6b","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/default/pods/web-0","verb":"delete","user":{"username":"kubernetes-admin","groups":["system:masters"]},"sourceIPs":["10.0.1.15"],"userAgent":"kubectl/v1.24.0","objectRef":{"resource":"pods","namespace":"default","name":"web-0","apiVersion":"v1"},"responseStatus":{"metadata":{},"code":200},"requestReceivedTimestamp":"2022-08-25T14:06:08.442600Z","stageTimestamp":"2022-08-25T14:06:08.452600Z"}`
+
+		metadata := parseEKSControlPlaneLog("kube-apiserver-audit-4ea105b859276d339937212f26506657", message)
+
+		assert.Equal(t, map[string]string{
+			"eks.logType":         "audit",
+			"auditID":             "e3c9a0c5-1f3a-4b0c-9f3e-1c2d3e4f5a6b",
+			"stage":               "ResponseComplete",
+			"verb":                "delete",
+			"requestURI":          "/api/v1/namespaces/default/pods/web-0",
+			"sourceIPs":           "10.0.1.15",
+			"userAgent":           "kubectl/v1.24.0",
+			"user.username":       "kubernetes-admin",
+			"objectRef.resource":  "pods",
+			"objectRef.namespace": "default",
+			"objectRef.name":      "web-0",
+			"responseStatus.code": "200",
+		}, metadata)
+	})
+
+	t.Run("malformed audit event", func(t *testing.T) {
+		metadata := parseEKSControlPlaneLog("kube-apiserver-audit-4ea105b859276d339937212f26506657", "not json")
+		assert.Equal(t, map[string]string{"eks.logType": "audit"}, metadata)
+	})
+}
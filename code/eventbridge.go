@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// isEventBridgeEvent reports whether an invocation is an event delivered by an
+// EventBridge rule.
+func isEventBridgeEvent(data map[string]interface{}) bool {
+	_, hasDetailType := data["detail-type"]
+	_, hasSource := data["source"]
+	return hasDetailType && hasSource
+}
+
+// isEventBridgeS3Event reports whether an EventBridge event notifies the
+// creation of an S3 object, to be read like a classic S3 notification.
+func isEventBridgeS3Event(data map[string]interface{}) bool {
+	return data["source"] == "aws.s3" && data["detail-type"] == "Object Created"
+}
+
+// eventBridgeS3Detail holds the fields of the detail of an S3 Object Created
+// event needed to read the object.
+type eventBridgeS3Detail struct {
+	Bucket struct {
+		Name string `json:"name"`
+	} `json:"bucket"`
+	Object struct {
+		Key       string `json:"key"`
+		Size      int64  `json:"size"`
+		ETag      string `json:"etag"`
+		Sequencer string `json:"sequencer"`
+	} `json:"object"`
+	Reason string `json:"reason"`
+}
+
+// s3EventFromEventBridge converts an S3 Object Created event delivered by
+// EventBridge to the S3 notification the S3 based parsers read.
+func s3EventFromEventBridge(event events.CloudWatchEvent) events.S3Event {
+	var detail eventBridgeS3Detail
+	err := json.Unmarshal(event.Detail, &detail)
+	handleFatalError("failed to unmarshal s3 object created event detail", err)
+	bucketARN, _ := buildGlobalARN("s3", event.Region, "", detail.Bucket.Name)
+
+	return events.S3Event{
+		Records: []events.S3EventRecord{{
+			EventSource: "aws:s3",
+			AWSRegion:   event.Region,
+			EventTime:   event.Time,
+			EventName:   "ObjectCreated:" + detail.Reason,
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: detail.Bucket.Name,
+					Arn:  bucketARN,
+				},
+				Object: events.S3Object{
+					Key:       detail.Object.Key,
+					Size:      detail.Object.Size,
+					ETag:      detail.Object.ETag,
+					Sequencer: detail.Object.Sequencer,
+				},
+			},
+		}},
+	}
+}
+
+// parseEventBridgeEvent forwards an EventBridge event as a JSON log,
+// timestamped with its time and attached to the first resource it lists, or to
+// its account.
+func parseEventBridgeEvent(event events.CloudWatchEvent) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	message, err := json.Marshal(event)
+	if err != nil {
+		return lmBatch, fmt.Errorf("failed to marshal event %s: %s", event.ID, err)
+	}
+
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:    string(message),
+			ResourceID: eventBridgeResourceID(event),
+			Timestamp:  event.Time,
+		},
+		Metadata: eventBridgeAttributes(event),
+	}
+
+	lmBatch = append(lmBatch, lmEv)
+	return lmBatch, nil
+}
+
+// eventBridgeResourceID maps an event to the first valid ARN in its
+// resources, falling back to its account.
+func eventBridgeResourceID(event events.CloudWatchEvent) map[string]string {
+	for _, arn := range event.Resources {
+		if validateARN(arn) == nil {
+			return map[string]string{"system.aws.arn": arn}
+		}
+	}
+	return accountResourceID(resolveAccountID(event.AccountID))
+}
+
+// eventBridgeAttributes returns the envelope fields of an event promoted to
+// log attributes.
+func eventBridgeAttributes(event events.CloudWatchEvent) map[string]string {
+	fields := map[string]string{
+		"id":          event.ID,
+		"detail-type": event.DetailType,
+		"source":      event.Source,
+		"region":      event.Region,
+	}
+	return attributes(fields)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEventBridgeEvent(t *testing.T) {
+	eventJson := `{"version":"0","id":"7bf73129-1428-4cd3-a780-95db273d1602","detail-type":"EC2 Instance State-change Notification","source":"aws.ec2","account":"197152445587","time":"2021-04-26T05:50:00Z","region":"ap-northeast-1","resources":["arn:aws:ec2:ap-northeast-1:197152445587:instance/i-0d345eec77c8a08b1"],"detail":{"instance-id":"i-0d345eec77c8a08b1","state":"stopped"}}`
+
+	var event map[string]interface{}
+	_ = json.Unmarshal([]byte(eventJson), &event)
+	assert.Equal(t, "eventbridge", ParseEventType(event))
+
+	lmEvents, err := parseEventBridgeEvent(convertToEventBridgeEvent(event))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(lmEvents))
+	assert.JSONEq(t, eventJson, lmEvents[0].Message)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2:ap-northeast-1:197152445587:instance/i-0d345eec77c8a08b1"}, lmEvents[0].ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC), lmEvents[0].Timestamp)
+	assert.Equal(t, map[string]string{
+		"id":          "7bf73129-1428-4cd3-a780-95db273d1602",
+		"detail-type": "EC2 Instance State-change Notification",
+		"source":      "aws.ec2",
+		"region":      "ap-northeast-1",
+	}, lmEvents[0].Metadata)
+}
+
+func TestParseEventBridgeEventWithoutResources(t *testing.T) {
+	eventJson := `{"version":"0","id":"0e4ac6a1-3c2f-4bd4-9a1c-5a8e7d9f0b11","detail-type":"AWS Health Event","source":"aws.health","account":"197152445587","time":"2021-04-26T05:50:00Z","region":"ap-northeast-1","resources":[],"detail":{"eventTypeCode":"AWS_EC2_OPERATIONAL_ISSUE"}}`
+
+	var event map[string]interface{}
+	_ = json.Unmarshal([]byte(eventJson), &event)
+
+	lmEvents, err := parseEventBridgeEvent(convertToEventBridgeEvent(event))
+
+	assert.NoError(t, err)
+	assert.Equal(t, accountResourceID("197152445587"), lmEvents[0].ResourceID)
+}
+
+func TestParseEventTypeEventBridgeS3(t *testing.T) {
+	keys := map[string]string{
+		"AWSLogs/197152445587/elasticloadbalancing/us-east-1/2021/04/26/197152445587_elasticloadbalancing_us-east-1_app.test.50dc6c495c0c9188_20210426T0550Z.log.gz": "elb",
+		"AWSLogs/197152445587/CloudTrail/us-east-1/2021/04/26/197152445587_CloudTrail_us-east-1_20210426T0550Z_xQQbx1hRL6YkH4mF.json.gz":                             "cloudtrail",
+		"access-logs/2021-04-26-05-50-00-5E2D8F4C8B0A1D3E": "s3",
+	}
+	for key, eventType := range keys {
+		event := map[string]interface{}{
+			"version":     "0",
+			"id":          "17793124-05d4-b198-2fde-7ededc63b103",
+			"detail-type": "Object Created",
+			"source":      "aws.s3",
+			"account":     "197152445587",
+			"time":        "2021-04-26T05:50:00Z",
+			"region":      "us-east-1",
+			"resources":   []interface{}{"arn:aws:s3:::log-bucket"},
+			"detail": map[string]interface{}{
+				"version": "0",
+				"bucket":  map[string]interface{}{"name": "log-bucket"},
+				"object":  map[string]interface{}{"key": key, "size": 5, "etag": "b1946ac92492d2347c6235b4d2611184", "sequencer": "00617F08299329D189"},
+				"reason":  "PutObject",
+			},
+		}
+
+		assert.Equal(t, eventType, ParseEventType(event), key)
+
+		s3Event := convertToS3Event(event)
+		assert.Equal(t, 1, len(s3Event.Records))
+		assert.Equal(t, "log-bucket", s3Event.Records[0].S3.Bucket.Name)
+		assert.Equal(t, "arn:aws:s3:::log-bucket", s3Event.Records[0].S3.Bucket.Arn)
+		assert.Equal(t, key, s3Event.Records[0].S3.Object.Key)
+		assert.Equal(t, "us-east-1", s3Event.Records[0].AWSRegion)
+		assert.Equal(t, "ObjectCreated:PutObject", s3Event.Records[0].EventName)
+		assert.Equal(t, time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC), s3Event.Records[0].EventTime)
+	}
+}
+
+func TestParseEventTypeEventBridgeS3OtherEvents(t *testing.T) {
+	event := map[string]interface{}{
+		"detail-type": "Object Deleted",
+		"source":      "aws.s3",
+		"detail":      map[string]interface{}{"bucket": map[string]interface{}{"name": "log-bucket"}},
+	}
+
+	assert.Equal(t, "eventbridge", ParseEventType(event))
+}
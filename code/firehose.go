@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// firehoseAccessKey is the access key Firehose HTTP endpoint deliveries must
+// carry. Deliveries are refused when it is not configured.
+var firehoseAccessKey string
+
+// httpListenAddress runs the forwarder as a standalone Firehose HTTP endpoint
+// listening on this address instead of as a Lambda function.
+var httpListenAddress string
+
+// firehoseNormalizeRecords makes the forwarder, as a Firehose transformation
+// function, return the records it read logs from as newline-delimited
+// messages, such as the log events of CloudWatch Logs payloads, rather than
+// unchanged.
+var firehoseNormalizeRecords bool
+
+// firehoseHTTPRequest is the body of a Firehose HTTP endpoint delivery.
+type firehoseHTTPRequest struct {
+	RequestID string `json:"requestId"`
+	Timestamp int64  `json:"timestamp"`
+	Records   []struct {
+		Data []byte `json:"data"`
+	} `json:"records"`
+}
+
+// firehoseHTTPResponse acknowledges a Firehose HTTP endpoint delivery, or
+// explains why it failed so Firehose retries it.
+type firehoseHTTPResponse struct {
+	RequestID    string `json:"requestId"`
+	Timestamp    int64  `json:"timestamp"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+func newFirehoseHTTPResponse(requestID string) firehoseHTTPResponse {
+	return firehoseHTTPResponse{
+		RequestID: requestID,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// ForwardLogs sends the logs of a request to LogicMonitor, returning an error
+// when they must be delivered again.
+type ForwardLogs func([]LMLog) error
+
+// forwardLogs enriches, scrubs and sends logs extracted from any trigger.
+func forwardLogs(logs []LMLog) error {
+	enrichWithResourceTags(logs, getResourceTags, getBucketRegion)
+	ScrubLogsWithRegex(logs)
+	return SendLogs(logs)
+}
+
+// isFunctionURLRequest reports whether an invocation is an HTTP request made
+// to the Lambda function URL.
+func isFunctionURLRequest(request interface{}) bool {
+	data, ok := request.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	requestContext, ok := data["requestContext"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = requestContext["http"]
+	return ok
+}
+
+// serveFirehoseHTTPRequest authenticates a Firehose HTTP endpoint delivery,
+// forwards the logs of its records and returns the status and body of the
+// response.
+func serveFirehoseHTTPRequest(method string, header http.Header, body []byte, forward ForwardLogs) (int, firehoseHTTPResponse) {
+	response := newFirehoseHTTPResponse(header.Get("X-Amz-Firehose-Request-Id"))
+
+	if method != http.MethodPost {
+		response.ErrorMessage = fmt.Sprintf("method %s not allowed", method)
+		return http.StatusMethodNotAllowed, response
+	}
+
+	accessKey := header.Get("X-Amz-Firehose-Access-Key")
+	if firehoseAccessKey == "" || subtle.ConstantTimeCompare([]byte(accessKey), []byte(firehoseAccessKey)) != 1 {
+		response.ErrorMessage = "invalid access key"
+		return http.StatusUnauthorized, response
+	}
+
+	if header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err == nil {
+			body, err = ioutil.ReadAll(reader)
+		}
+		if err != nil {
+			response.ErrorMessage = fmt.Sprintf("failed to decompress request body: %s", err)
+			return http.StatusBadRequest, response
+		}
+	}
+
+	var request firehoseHTTPRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		response.ErrorMessage = fmt.Sprintf("failed to parse request body: %s", err)
+		return http.StatusBadRequest, response
+	}
+	if request.RequestID != "" {
+		response.RequestID = request.RequestID
+	}
+
+	sourceARN := header.Get("X-Amz-Firehose-Source-Arn")
+	timestamp := time.Unix(0, request.Timestamp*int64(time.Millisecond))
+
+	lmBatch := make([]LMLog, 0)
+	for _, record := range request.Records {
+		lmBatch = append(lmBatch, parseStreamRecord(record.Data, sourceARN, timestamp)...)
+	}
+	err = forward(lmBatch)
+	if err != nil {
+		response.ErrorMessage = fmt.Sprintf("failed to send logs: %s", err)
+		return http.StatusInternalServerError, response
+	}
+
+	return http.StatusOK, response
+}
+
+// handleFunctionURLRequest serves a Firehose HTTP endpoint delivery made to
+// the Lambda function URL.
+func handleFunctionURLRequest(request events.APIGatewayV2HTTPRequest, forward ForwardLogs) events.APIGatewayV2HTTPResponse {
+	header := make(http.Header)
+	for name, value := range request.Headers {
+		header.Set(name, value)
+	}
+
+	body := []byte(request.Body)
+	status, response := http.StatusBadRequest, newFirehoseHTTPResponse(header.Get("X-Amz-Firehose-Request-Id"))
+	var err error
+	if request.IsBase64Encoded {
+		body, err = base64.StdEncoding.DecodeString(request.Body)
+	}
+	if err != nil {
+		response.ErrorMessage = fmt.Sprintf("failed to decode request body: %s", err)
+	} else {
+		status, response = serveFirehoseHTTPRequest(request.RequestContext.HTTP.Method, header, body, forward)
+	}
+
+	if response.ErrorMessage != "" {
+		fmt.Printf("WARN firehose request %s failed: %s\n", response.RequestID, response.ErrorMessage)
+	}
+
+	responseBody, _ := json.Marshal(response)
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(responseBody),
+	}
+}
+
+// firehoseHTTPHandler serves Firehose HTTP endpoint deliveries when the
+// forwarder runs as a standalone server.
+func firehoseHTTPHandler(forward ForwardLogs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, response := http.StatusBadRequest, newFirehoseHTTPResponse(r.Header.Get("X-Amz-Firehose-Request-Id"))
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			response.ErrorMessage = fmt.Sprintf("failed to read request body: %s", err)
+		} else {
+			status, response = serveFirehoseHTTPRequest(r.Method, r.Header, body, forward)
+		}
+
+		if response.ErrorMessage != "" {
+			fmt.Printf("WARN firehose request %s failed: %s\n", response.RequestID, response.ErrorMessage)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// isFirehoseTransformationEvent reports whether an invocation asks the
+// forwarder to transform records of a Firehose delivery stream.
+func isFirehoseTransformationEvent(request interface{}) bool {
+	data, ok := request.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, hasDeliveryStream := data["deliveryStreamArn"]
+	_, hasRecords := data["records"]
+	return hasDeliveryStream && hasRecords
+}
+
+// transformFirehoseRecords forwards the logs of the records of a Firehose
+// transformation invocation and returns every record as Ok, so the delivery
+// stream goes on delivering them to its destination. When the logs cannot be
+// sent, the error fails the invocation so Firehose retries it.
+func transformFirehoseRecords(request events.KinesisFirehoseEvent, forward ForwardLogs) (events.KinesisFirehoseResponse, error) {
+	lmBatch := make([]LMLog, 0)
+	response := events.KinesisFirehoseResponse{
+		Records: make([]events.KinesisFirehoseResponseRecord, 0, len(request.Records)),
+	}
+
+	for _, record := range request.Records {
+		logs := parseStreamRecord(record.Data, request.DeliveryStreamArn, record.ApproximateArrivalTimestamp.Time)
+		lmBatch = append(lmBatch, logs...)
+
+		data := record.Data
+		if firehoseNormalizeRecords && len(logs) > 0 {
+			messages := make([]string, 0, len(logs))
+			for _, lmEv := range logs {
+				messages = append(messages, lmEv.Message)
+			}
+			data = []byte(strings.Join(messages, "\n") + "\n")
+		}
+
+		response.Records = append(response.Records, events.KinesisFirehoseResponseRecord{
+			RecordID: record.RecordID,
+			Result:   events.KinesisFirehoseTransformedStateOk,
+			Data:     data,
+		})
+	}
+
+	err := forward(lmBatch)
+	if err != nil {
+		return events.KinesisFirehoseResponse{}, err
+	}
+	return response, nil
+}
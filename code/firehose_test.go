@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func firehoseHTTPBodyFor(data ...string) string {
+	records := make([]map[string]string, 0)
+	for _, record := range data {
+		records = append(records, map[string]string{"data": base64.StdEncoding.EncodeToString([]byte(record))})
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"requestId": "ed4acda5-034f-9f42-bba1-f29aea6d7d8f",
+		"timestamp": 1619416294250,
+		"records":   records,
+	})
+	return string(body)
+}
+
+// collectLogs returns a ForwardLogs keeping the logs it is given.
+func collectLogs(forwarded *[]LMLog) ForwardLogs {
+	return func(logs []LMLog) error {
+		*forwarded = logs
+		return nil
+	}
+}
+
+func TestServeFirehoseHTTPRequest(t *testing.T) {
+	firehoseAccessKey = "s3cr3t"
+	awsRegion = "ap-northeast-1"
+	defer func() { firehoseAccessKey, awsRegion = "", "" }()
+
+	cloudWatchData, _ := json.Marshal(events.CloudwatchLogsData{
+		MessageType: "DATA_MESSAGE",
+		Owner:       "197152445587",
+		LogGroup:    "/aws/lambda/checkout",
+		LogEvents:   []events.CloudwatchLogsLogEvent{{ID: "1", Timestamp: 1619416294250, Message: "START RequestId: 6e8b7a4c"}},
+	})
+	body := firehoseHTTPBodyFor(gzipString(string(cloudWatchData)), "plain record\n")
+
+	header := make(http.Header)
+	header.Set("X-Amz-Firehose-Request-Id", "ed4acda5-034f-9f42-bba1-f29aea6d7d8f")
+	header.Set("X-Amz-Firehose-Source-Arn", "arn:aws:firehose:ap-northeast-1:197152445587:deliverystream/logs-to-lm")
+	header.Set("X-Amz-Firehose-Access-Key", "s3cr3t")
+
+	t.Run("valid delivery", func(t *testing.T) {
+		var forwarded []LMLog
+		status, response := serveFirehoseHTTPRequest(http.MethodPost, header, []byte(body), collectLogs(&forwarded))
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ed4acda5-034f-9f42-bba1-f29aea6d7d8f", response.RequestID)
+		assert.Empty(t, response.ErrorMessage)
+		assert.Equal(t, 2, len(forwarded))
+		assert.Equal(t, "START RequestId: 6e8b7a4c", forwarded[0].Message)
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:lambda:ap-northeast-1:197152445587:function:checkout"}, forwarded[0].ResourceID)
+		assert.Equal(t, "plain record", forwarded[1].Message)
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:firehose:ap-northeast-1:197152445587:deliverystream/logs-to-lm"}, forwarded[1].ResourceID)
+		assert.Equal(t, time.Date(2021, time.April, 26, 5, 51, 34, 250000000, time.UTC), forwarded[1].Timestamp.UTC())
+	})
+
+	t.Run("gzip content encoding", func(t *testing.T) {
+		gzipHeader := header.Clone()
+		gzipHeader.Set("Content-Encoding", "gzip")
+
+		var forwarded []LMLog
+		status, _ := serveFirehoseHTTPRequest(http.MethodPost, gzipHeader, []byte(gzipString(body)), collectLogs(&forwarded))
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 2, len(forwarded))
+	})
+
+	t.Run("invalid access key", func(t *testing.T) {
+		invalidHeader := header.Clone()
+		invalidHeader.Set("X-Amz-Firehose-Access-Key", "guess")
+
+		var forwarded []LMLog
+		status, response := serveFirehoseHTTPRequest(http.MethodPost, invalidHeader, []byte(body), collectLogs(&forwarded))
+
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, "invalid access key", response.ErrorMessage)
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("failed ingestion", func(t *testing.T) {
+		status, response := serveFirehoseHTTPRequest(http.MethodPost, header, []byte(body), func(logs []LMLog) error {
+			return errors.New("logs were not accepted")
+		})
+
+		assert.Equal(t, http.StatusInternalServerError, status)
+		assert.Equal(t, "failed to send logs: logs were not accepted", response.ErrorMessage)
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		status, response := serveFirehoseHTTPRequest(http.MethodPost, header, []byte("{"), func(logs []LMLog) error { return nil })
+
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "ed4acda5-034f-9f42-bba1-f29aea6d7d8f", response.RequestID)
+		assert.NotEmpty(t, response.ErrorMessage)
+	})
+}
+
+func TestHandleFunctionURLRequest(t *testing.T) {
+	firehoseAccessKey = "s3cr3t"
+	defer func() { firehoseAccessKey = "" }()
+
+	event := map[string]interface{}{
+		"version": "2.0",
+		"rawPath": "/",
+		"headers": map[string]interface{}{
+			"x-amz-firehose-request-id": "ed4acda5-034f-9f42-bba1-f29aea6d7d8f",
+			"x-amz-firehose-access-key": "s3cr3t",
+		},
+		"requestContext": map[string]interface{}{
+			"http": map[string]interface{}{"method": "POST", "path": "/"},
+		},
+		"body":            base64.StdEncoding.EncodeToString([]byte(firehoseHTTPBodyFor("plain record"))),
+		"isBase64Encoded": true,
+	}
+	assert.True(t, isFunctionURLRequest(event))
+
+	var forwarded []LMLog
+	response := handleFunctionURLRequest(convertToFunctionURLRequest(event), collectLogs(&forwarded))
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/json", response.Headers["Content-Type"])
+	assert.Contains(t, response.Body, `"requestId":"ed4acda5-034f-9f42-bba1-f29aea6d7d8f"`)
+	assert.Equal(t, 1, len(forwarded))
+	assert.Equal(t, "plain record", forwarded[0].Message)
+}
+
+func TestFirehoseHTTPHandler(t *testing.T) {
+	firehoseAccessKey = "s3cr3t"
+	defer func() { firehoseAccessKey = "" }()
+
+	var forwarded []LMLog
+	server := httptest.NewServer(firehoseHTTPHandler(collectLogs(&forwarded)))
+	defer server.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(firehoseHTTPBodyFor("plain record")))
+	request.Header.Set("X-Amz-Firehose-Access-Key", "s3cr3t")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+
+	var acknowledgement firehoseHTTPResponse
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&acknowledgement))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "ed4acda5-034f-9f42-bba1-f29aea6d7d8f", acknowledgement.RequestID)
+	assert.Equal(t, 1, len(forwarded))
+
+	response, err = http.Get(server.URL)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+}
+
+func TestTransformFirehoseRecords(t *testing.T) {
+	awsRegion = "ap-northeast-1"
+	defer func() { awsRegion = "" }()
+
+	cloudWatchData, _ := json.Marshal(events.CloudwatchLogsData{
+		MessageType: "DATA_MESSAGE",
+		Owner:       "197152445587",
+		LogGroup:    "/aws/lambda/checkout",
+		LogEvents: []events.CloudwatchLogsLogEvent{
+			{ID: "1", Timestamp: 1619416294250, Message: "START RequestId: 6e8b7a4c"},
+			{ID: "2", Timestamp: 1619416294300, Message: "END RequestId: 6e8b7a4c"},
+		},
+	})
+	event := map[string]interface{}{
+		"invocationId":      "invocationIdExample",
+		"deliveryStreamArn": "arn:aws:firehose:ap-northeast-1:197152445587:deliverystream/logs-to-s3",
+		"region":            "ap-northeast-1",
+		"records": []interface{}{
+			map[string]interface{}{
+				"recordId":                    "49546986683135544286507457936321625675700192471156785154",
+				"approximateArrivalTimestamp": 1619416294250,
+				"data":                        base64.StdEncoding.EncodeToString([]byte(gzipString(string(cloudWatchData)))),
+			},
+			map[string]interface{}{
+				"recordId":                    "49546986683135544286507457936321625675700192471156785155",
+				"approximateArrivalTimestamp": 1619416294300,
+				"data":                        base64.StdEncoding.EncodeToString([]byte("plain record\n")),
+			},
+		},
+	}
+	assert.True(t, isFirehoseTransformationEvent(event))
+	assert.False(t, isFunctionURLRequest(event))
+
+	t.Run("unchanged records", func(t *testing.T) {
+		var forwarded []LMLog
+		response, err := transformFirehoseRecords(convertToFirehoseEvent(event), collectLogs(&forwarded))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(forwarded))
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:lambda:ap-northeast-1:197152445587:function:checkout"}, forwarded[0].ResourceID)
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:firehose:ap-northeast-1:197152445587:deliverystream/logs-to-s3"}, forwarded[2].ResourceID)
+
+		assert.Equal(t, 2, len(response.Records))
+		assert.Equal(t, "49546986683135544286507457936321625675700192471156785154", response.Records[0].RecordID)
+		assert.Equal(t, "Ok", response.Records[0].Result)
+		assert.Equal(t, gzipString(string(cloudWatchData)), string(response.Records[0].Data))
+		assert.Equal(t, "Ok", response.Records[1].Result)
+		assert.Equal(t, "plain record\n", string(response.Records[1].Data))
+	})
+
+	t.Run("failed ingestion", func(t *testing.T) {
+		_, err := transformFirehoseRecords(convertToFirehoseEvent(event), func(logs []LMLog) error {
+			return errors.New("logs were not accepted")
+		})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("normalized records", func(t *testing.T) {
+		firehoseNormalizeRecords = true
+		defer func() { firehoseNormalizeRecords = false }()
+
+		response, err := transformFirehoseRecords(convertToFirehoseEvent(event), func(logs []LMLog) error { return nil })
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Ok", response.Records[0].Result)
+		assert.Equal(t, "START RequestId: 6e8b7a4c\nEND RequestId: 6e8b7a4c\n", string(response.Records[0].Data))
+		assert.Equal(t, "plain record\n", string(response.Records[1].Data))
+	})
+}
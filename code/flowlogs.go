@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// defaultFlowLogFields are the fields of the default (version 2) flow log
+// record format.
+var defaultFlowLogFields = []string{
+	"version", "account-id", "interface-id", "srcaddr", "dstaddr", "srcport", "dstport",
+	"protocol", "packets", "bytes", "start", "end", "action", "log-status",
+}
+
+var flowLogLogGroupRegex = regexp.MustCompile(`^/aws/(ec2|natGateway|elb|rds[^/]*)/networkInterface$`)
+
+var flowLogFieldRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// vpcFlowLogS3KeyRegex matches the keys of flow log files delivered to S3,
+// with or without Hive-compatible prefixes.
+var vpcFlowLogS3KeyRegex = regexp.MustCompile(`AWSLogs/(aws-account-id=)?(?P<account>\d{12})/(aws-service=)?vpcflowlogs/(aws-region=)?(?P<region>[a-z0-9-]+)/`)
+
+// flowLogFormats holds the custom record format of flow log groups, keyed by
+// log group name.
+var flowLogFormats map[string][]string
+
+// flowLogAggregation rolls up the flow log records of each invocation, keeping
+// rejected flows as they are when flowLogKeepRejected is set.
+var flowLogAggregation, flowLogKeepRejected bool
+
+// flowLogAggregationFields identify the flows rolled up together, in addition
+// to their resource.
+var flowLogAggregationFields = []string{"srcaddr", "dstaddr", "dstport", "protocol", "action"}
+
+// parseFlowLogFormats reads a JSON object mapping log group names to their
+// flow log record format, written as in the flow log configuration
+// (${version} ${account-id} ...) or as plain field names.
+func parseFlowLogFormats(config string) (map[string][]string, error) {
+	formats := make(map[string][]string)
+	if strings.TrimSpace(config) == "" {
+		return formats, nil
+	}
+
+	var rawFormats map[string]string
+	err := json.Unmarshal([]byte(config), &rawFormats)
+	if err != nil {
+		return nil, err
+	}
+
+	for logGroup, rawFormat := range rawFormats {
+		fields, err := parseFlowLogFormat(rawFormat)
+		if err != nil {
+			return nil, fmt.Errorf("log group %s: %s", logGroup, err)
+		}
+		formats[logGroup] = fields
+	}
+	return formats, nil
+}
+
+// parseFlowLogFormat returns the field names of a flow log record format. It
+// also reads the header line of flow log files delivered to S3.
+func parseFlowLogFormat(format string) ([]string, error) {
+	fields := make([]string, 0)
+	for _, field := range strings.Fields(format) {
+		field = strings.TrimSuffix(strings.TrimPrefix(field, "${"), "}")
+		if !flowLogFieldRegex.MatchString(field) {
+			return nil, fmt.Errorf("invalid flow log field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty flow log format")
+	}
+	return fields, nil
+}
+
+// isFlowLogGroup reports whether a log group carries flow logs, either as one
+// of the built-in flow log groups or with a configured format.
+func isFlowLogGroup(logGroup string) bool {
+	_, ok := flowLogFormats[logGroup]
+	return ok || flowLogLogGroupRegex.MatchString(logGroup)
+}
+
+// flowLogFieldsForLogGroup returns the configured format of a log group, or
+// the default format.
+func flowLogFieldsForLogGroup(logGroup string) []string {
+	if fields, ok := flowLogFormats[logGroup]; ok {
+		return fields
+	}
+	return defaultFlowLogFields
+}
+
+// parseFlowLogRecord returns the fields of a flow log record by name, leaving
+// out those without a value. It returns nil when the record does not have the
+// expected number of fields or a numeric version, as with the instance-first
+// custom format of EC2 flow logs read as the default format.
+func parseFlowLogRecord(fields []string, message string) map[string]string {
+	values := strings.Fields(message)
+	if len(values) != len(fields) {
+		return nil
+	}
+
+	record := make(map[string]string)
+	for i, field := range fields {
+		if values[i] != "-" {
+			record[field] = values[i]
+		}
+	}
+
+	if version, ok := record["version"]; ok {
+		if _, err := strconv.Atoi(version); err != nil {
+			return nil
+		}
+	}
+	return record
+}
+
+// flowLogRecordResourceID maps a flow log record to the EC2 instance it was
+// captured for, or else its network interface. It returns nil when the record
+// has neither.
+func flowLogRecordResourceID(record map[string]string, region string, owner string) map[string]string {
+	if instanceID := record["instance-id"]; ec2InstanceIDRegex.MatchString(instanceID) {
+		region := resolveRegion(record["region"], region)
+		accountID := resolveAccountID(record["account-id"], owner)
+		arn, err := buildARN("ec2", region, accountID, "instance/"+instanceID)
+		if err == nil {
+			return map[string]string{"system.aws.arn": arn}
+		}
+	}
+	if interfaceID := record["interface-id"]; strings.HasPrefix(interfaceID, "eni-") {
+		return map[string]string{"system.aws.networkInterfaceId": interfaceID}
+	}
+	return nil
+}
+
+// flowLogResourceID returns the resource of a flow log event delivered to
+// CloudWatch Logs. Configured resource mapping rules take precedence over the
+// instance or interface of the record.
+func flowLogResourceID(data events.CloudwatchLogsData, region string, message string, record map[string]string) map[string]string {
+	if resourceID, ok := matchResourceMappingRules(resourceMappingRules, data, region, message); ok {
+		return resourceID
+	}
+	if resourceID := flowLogRecordResourceID(record, region, data.Owner); resourceID != nil {
+		return resourceID
+	}
+	return resolveResourceID(data, region, message)
+}
+
+// parseFlowLogS3Logs splits a flow log file delivered to S3, as text with a
+// header line or in Apache Parquet, into one event per record.
+func parseFlowLogS3Logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	bucketName := request.Records[0].S3.Bucket.Name
+	key := request.Records[0].S3.Object.Key
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
+	}
+
+	var owner, region string
+	if keyMatches := vpcFlowLogS3KeyRegex.FindStringSubmatch(key); keyMatches != nil {
+		owner = keyMatches[vpcFlowLogS3KeyRegex.SubexpIndex("account")]
+		region = keyMatches[vpcFlowLogS3KeyRegex.SubexpIndex("region")]
+	}
+
+	var fields, lines []string
+	if strings.HasPrefix(content, parquetMagic) {
+		fields, lines, err = readParquetFlowLogs(content)
+		if err != nil {
+			return lmBatch, fmt.Errorf("failed to read flow log file %s: %s", key, err)
+		}
+	} else {
+		lines = strings.Split(strings.TrimRight(content, "\n"), "\n")
+		fields, err = parseFlowLogFormat(lines[0])
+		if err != nil {
+			return lmBatch, fmt.Errorf("invalid header in flow log file %s: %s", key, err)
+		}
+		lines = lines[1:]
+	}
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		lmEv := LMLog{
+			Log: ingest.Log{
+				Message:    line,
+				ResourceID: accountResourceID(resolveAccountID(owner)),
+				Timestamp:  request.Records[0].EventTime,
+			},
+		}
+		if record := parseFlowLogRecord(fields, line); record != nil {
+			if resourceID := flowLogRecordResourceID(record, region, owner); resourceID != nil {
+				lmEv.ResourceID = resourceID
+			}
+			if start, err := strconv.ParseInt(record["start"], 10, 64); err == nil {
+				lmEv.Timestamp = time.Unix(start, 0)
+			}
+			lmEv.Metadata = record
+		}
+		lmBatch = append(lmBatch, lmEv)
+	}
+	return lmBatch, nil
+}
+
+type flowLogSummary struct {
+	lmEv                  LMLog
+	flows, packets, bytes int64
+	firstSeen, lastSeen   int64
+}
+
+// aggregateFlowLogs rolls up parsed flow log records sharing a resource,
+// source, destination, destination port, protocol and action into one event
+// with their packet and byte totals and the time range they were seen over.
+// Records that were not parsed or carry no traffic are kept as they are.
+func aggregateFlowLogs(logs []LMLog) []LMLog {
+	aggregated := make([]LMLog, 0)
+	summaries := make(map[string]*flowLogSummary)
+	order := make([]string, 0)
+
+	for _, lmEv := range logs {
+		record := lmEv.Metadata
+		if record == nil || record["srcaddr"] == "" || (flowLogKeepRejected && record["action"] == "REJECT") {
+			aggregated = append(aggregated, lmEv)
+			continue
+		}
+
+		keyParts := []string{fmt.Sprint(lmEv.ResourceID)}
+		for _, field := range flowLogAggregationFields {
+			keyParts = append(keyParts, record[field])
+		}
+		key := strings.Join(keyParts, " ")
+
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &flowLogSummary{lmEv: lmEv}
+			summaries[key] = summary
+			order = append(order, key)
+		}
+
+		summary.flows++
+		packets, _ := strconv.ParseInt(record["packets"], 10, 64)
+		summary.packets += packets
+		bytes, _ := strconv.ParseInt(record["bytes"], 10, 64)
+		summary.bytes += bytes
+		if start, err := strconv.ParseInt(record["start"], 10, 64); err == nil && (summary.firstSeen == 0 || start < summary.firstSeen) {
+			summary.firstSeen = start
+		}
+		if end, err := strconv.ParseInt(record["end"], 10, 64); err == nil && end > summary.lastSeen {
+			summary.lastSeen = end
+		}
+		if lmEv.Timestamp.Before(summary.lmEv.Timestamp) {
+			summary.lmEv.Timestamp = lmEv.Timestamp
+		}
+	}
+
+	for _, key := range order {
+		aggregated = append(aggregated, summarizeFlowLogs(summaries[key]))
+	}
+	return aggregated
+}
+
+// summarizeFlowLogs returns the event of rolled up flows, written like a flow
+// log record of the aggregation fields followed by the totals and time range.
+func summarizeFlowLogs(summary *flowLogSummary) LMLog {
+	metadata := make(map[string]string)
+	values := make([]string, 0)
+	for _, field := range flowLogAggregationFields {
+		value := summary.lmEv.Metadata[field]
+		if value == "" {
+			value = "-"
+		} else {
+			metadata[field] = value
+		}
+		values = append(values, value)
+	}
+
+	totals := []struct {
+		field string
+		value int64
+	}{
+		{"flows", summary.flows},
+		{"packets", summary.packets},
+		{"bytes", summary.bytes},
+		{"start", summary.firstSeen},
+		{"end", summary.lastSeen},
+	}
+	for _, total := range totals {
+		value := strconv.FormatInt(total.value, 10)
+		if (total.field == "start" || total.field == "end") && total.value == 0 {
+			value = "-"
+		} else {
+			metadata[total.field] = value
+		}
+		values = append(values, value)
+	}
+
+	return LMLog{
+		Log: ingest.Log{
+			Message:    strings.Join(values, " "),
+			ResourceID: summary.lmEv.ResourceID,
+			Timestamp:  summary.lmEv.Timestamp,
+		},
+		Metadata: metadata,
+	}
+}
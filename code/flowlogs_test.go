@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/stretchr/testify/assert"
+)
+
+func cloudWatchEventFor(data events.CloudwatchLogsData) events.CloudwatchLogsEvent {
+	content, _ := json.Marshal(data)
+	return events.CloudwatchLogsEvent{
+		AWSLogs: events.CloudwatchLogsRawData{
+			Data: base64.StdEncoding.EncodeToString([]byte(gzipString(string(content)))),
+		},
+	}
+}
+
+func TestParseFlowLogFormats(t *testing.T) {
+	formats, err := parseFlowLogFormats(`{"/vpc/flows": "${instance-id} ${srcaddr} ${dstaddr} ${action}", "/vpc/plain": "version interface-id"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"/vpc/flows": {"instance-id", "srcaddr", "dstaddr", "action"},
+		"/vpc/plain": {"version", "interface-id"},
+	}, formats)
+
+	_, err = parseFlowLogFormats(`{"/vpc/flows": "${srcaddr} $(dstaddr)"}`)
+	assert.Error(t, err)
+
+	_, err = parseFlowLogFormats(`{"/vpc/flows": " "}`)
+	assert.Error(t, err)
+}
+
+func TestParseFlowLogRecord(t *testing.T) {
+	record := parseFlowLogRecord(defaultFlowLogFields, "2 148849679107 eni-09c6cfd662c38fd4d - - - - - - - 1626937750 1626937809 - NODATA")
+	assert.Equal(t, map[string]string{
+		"version":      "2",
+		"account-id":   "148849679107",
+		"interface-id": "eni-09c6cfd662c38fd4d",
+		"start":        "1626937750",
+		"end":          "1626937809",
+		"log-status":   "NODATA",
+	}, record)
+
+	assert.Nil(t, parseFlowLogRecord(defaultFlowLogFields, "2 148849679107 eni-09c6cfd662c38fd4d"))
+	assert.Nil(t, parseFlowLogRecord(defaultFlowLogFields, "i-067b718e521cdf437 197152445587 eni-071fbace220860313 52.119.221.63 10.134.7.224 443 45224 6 18 6689 1616399355 1616399414 ACCEPT OK"))
+}
+
+func TestParseCloudWatchFlowLogs(t *testing.T) {
+	data := events.CloudwatchLogsData{
+		Owner:     "197152445587",
+		LogGroup:  "/aws/natGateway/networkInterface",
+		LogStream: "eni-16105f5a-accept",
+		LogEvents: []events.CloudwatchLogsLogEvent{
+			{Timestamp: 1617877079000, Message: "2 197152445587 eni-16105f5a 10.134.2.231 10.134.5.180 45748 443 6 66 23501 1617877079 1617877138 ACCEPT OK"},
+		},
+	}
+
+	t.Run("default format", func(t *testing.T) {
+		logs := parseCloudWatchLogs(cloudWatchEventFor(data))
+
+		assert.Equal(t, map[string]string{"system.aws.networkInterfaceId": "eni-16105f5a"}, logs[0].ResourceID)
+		assert.Equal(t, map[string]string{
+			"version":      "2",
+			"account-id":   "197152445587",
+			"interface-id": "eni-16105f5a",
+			"srcaddr":      "10.134.2.231",
+			"dstaddr":      "10.134.5.180",
+			"srcport":      "45748",
+			"dstport":      "443",
+			"protocol":     "6",
+			"packets":      "66",
+			"bytes":        "23501",
+			"start":        "1617877079",
+			"end":          "1617877138",
+			"action":       "ACCEPT",
+			"log-status":   "OK",
+		}, logs[0].Metadata)
+	})
+
+	t.Run("custom format", func(t *testing.T) {
+		flowLogFormats, _ = parseFlowLogFormats(`{"/vpc/flows": "${account-id} ${interface-id} ${srcaddr} ${dstaddr} ${dstport} ${action} ${instance-id}"}`)
+		defer func() { flowLogFormats = nil }()
+
+		customData := data
+		customData.LogGroup = "/vpc/flows"
+		customData.LogEvents = []events.CloudwatchLogsLogEvent{
+			{Timestamp: 1617877079000, Message: "197152445587 eni-071fbace220860313 52.119.221.63 10.134.7.224 443 ACCEPT i-067b718e521cdf437"},
+			{Timestamp: 1617877079000, Message: "197152445587 eni-16105f5a 10.134.2.231 10.134.5.180 443 REJECT -"},
+			{Timestamp: 1617877079000, Message: "197152445587 eni-0a1b2c3d 10.134.2.231 10.134.5.180 443 ACCEPT i-0a1b2c3d4e"},
+		}
+
+		logs := parseCloudWatchLogs(cloudWatchEventFor(customData))
+
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2::197152445587:instance/i-067b718e521cdf437"}, logs[0].ResourceID)
+		assert.Equal(t, "443", logs[0].Metadata["dstport"])
+		assert.Equal(t, map[string]string{"system.aws.networkInterfaceId": "eni-16105f5a"}, logs[1].ResourceID)
+		assert.Equal(t, "REJECT", logs[1].Metadata["action"])
+		assert.Equal(t, map[string]string{"system.aws.networkInterfaceId": "eni-0a1b2c3d"}, logs[2].ResourceID)
+	})
+
+	t.Run("configured rules take precedence", func(t *testing.T) {
+		resourceMappingRules, _ = parseResourceMappingRules(`[{"logGroup": "^/aws/natGateway/", "resourceId": {"system.aws.natGatewayId": "nat-0a1b2c3d"}}]`)
+		defer func() { resourceMappingRules = nil }()
+
+		logs := parseCloudWatchLogs(cloudWatchEventFor(data))
+
+		assert.Equal(t, map[string]string{"system.aws.natGatewayId": "nat-0a1b2c3d"}, logs[0].ResourceID)
+		assert.Equal(t, "ACCEPT", logs[0].Metadata["action"])
+	})
+}
+
+func TestParseFlowLogS3Logs(t *testing.T) {
+	awsRegion = "us-west-1"
+	defer func() { awsRegion = "" }()
+
+	t.Run("text", func(t *testing.T) {
+		key := "AWSLogs/197152445587/vpcflowlogs/ap-northeast-1/2021/04/08/197152445587_vpcflowlogs_ap-northeast-1_fl-0a1b2c3d4e5f67890_20210408T1000Z_a1b2c3d4.log.gz"
+		content := "version account-id interface-id srcaddr dstaddr dstport action instance-id\n" +
+			"5 197152445587 eni-071fbace220860313 52.119.221.63 10.134.7.224 443 ACCEPT i-067b718e521cdf437\n" +
+			"5 197152445587 eni-16105f5a 10.134.2.231 10.134.5.180 22 REJECT -\n" +
+			"5 197152445587 eni-16105f5a\n"
+
+		var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+			return gzipString(content)
+		}
+
+		lmEvents, err := parseFlowLogS3Logs(s3EventFor("flow-log-bucket", key), getContentsFromS3BucketMock)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(lmEvents))
+		assert.Equal(t, "5 197152445587 eni-071fbace220860313 52.119.221.63 10.134.7.224 443 ACCEPT i-067b718e521cdf437", lmEvents[0].Message)
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2:ap-northeast-1:197152445587:instance/i-067b718e521cdf437"}, lmEvents[0].ResourceID)
+		assert.Equal(t, "443", lmEvents[0].Metadata["dstport"])
+		assert.Equal(t, map[string]string{"system.aws.networkInterfaceId": "eni-16105f5a"}, lmEvents[1].ResourceID)
+		assert.Equal(t, "REJECT", lmEvents[1].Metadata["action"])
+		assert.Equal(t, accountResourceID("197152445587"), lmEvents[2].ResourceID)
+		assert.Nil(t, lmEvents[2].Metadata)
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		key := "AWSLogs/aws-account-id=197152445587/aws-service=vpcflowlogs/aws-region=eu-west-1/year=2021/month=04/day=08/hour=10/197152445587_vpcflowlogs_eu-west-1_fl-0a1b2c3d4e5f67890_20210408T1000Z_a1b2c3d4.log.parquet"
+
+		schema, err := parquetschema.ParseSchemaDefinition(`message flowlogs {
+			optional int32 version;
+			optional binary account_id (STRING);
+			optional binary interface_id (STRING);
+			optional binary srcaddr (STRING);
+			optional int32 dstport;
+			optional int64 bytes;
+			optional int64 start;
+			optional binary action (STRING);
+			optional binary instance_id (STRING);
+		}`)
+		assert.NoError(t, err)
+
+		var buf bytes.Buffer
+		writer := goparquet.NewFileWriter(&buf, goparquet.WithSchemaDefinition(schema), goparquet.WithCompressionCodec(parquet.CompressionCodec_GZIP))
+		assert.NoError(t, writer.AddData(map[string]interface{}{
+			"version": int32(5), "account_id": []byte("197152445587"), "interface_id": []byte("eni-071fbace220860313"), "srcaddr": []byte("52.119.221.63"),
+			"dstport": int32(443), "bytes": int64(6689), "start": int64(1617876000), "action": []byte("ACCEPT"), "instance_id": []byte("i-067b718e521cdf437"),
+		}))
+		assert.NoError(t, writer.AddData(map[string]interface{}{
+			"version": int32(5), "account_id": []byte("197152445587"), "interface_id": []byte("eni-16105f5a"), "start": int64(1617876060), "action": []byte("REJECT"),
+		}))
+		assert.NoError(t, writer.Close())
+
+		var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+			return buf.String()
+		}
+
+		lmEvents, err := parseFlowLogS3Logs(s3EventFor("flow-log-bucket", key), getContentsFromS3BucketMock)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(lmEvents))
+		assert.Equal(t, "5 197152445587 eni-071fbace220860313 52.119.221.63 443 6689 1617876000 ACCEPT i-067b718e521cdf437", lmEvents[0].Message)
+		assert.Equal(t, time.Unix(1617876000, 0), lmEvents[0].Timestamp)
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2:eu-west-1:197152445587:instance/i-067b718e521cdf437"}, lmEvents[0].ResourceID)
+		assert.Equal(t, map[string]string{
+			"version": "5", "account-id": "197152445587", "interface-id": "eni-071fbace220860313", "srcaddr": "52.119.221.63",
+			"dstport": "443", "bytes": "6689", "start": "1617876000", "action": "ACCEPT", "instance-id": "i-067b718e521cdf437",
+		}, lmEvents[0].Metadata)
+		assert.Equal(t, "5 197152445587 eni-16105f5a - - - 1617876060 REJECT -", lmEvents[1].Message)
+		assert.Equal(t, map[string]string{"system.aws.networkInterfaceId": "eni-16105f5a"}, lmEvents[1].ResourceID)
+	})
+}
+
+func TestAggregateFlowLogs(t *testing.T) {
+	flowLogAggregation = true
+	defer func() {
+		flowLogAggregation = false
+		flowLogKeepRejected = false
+	}()
+
+	data := events.CloudwatchLogsData{
+		Owner:     "197152445587",
+		LogGroup:  "/aws/natGateway/networkInterface",
+		LogStream: "eni-16105f5a-all",
+		LogEvents: []events.CloudwatchLogsLogEvent{
+			{Timestamp: 1617877139000, Message: "2 197152445587 eni-16105f5a 10.134.2.231 10.134.5.180 45748 443 6 66 23501 1617877079 1617877138 ACCEPT OK"},
+			{Timestamp: 1617877079000, Message: "2 197152445587 eni-16105f5a 10.134.2.231 10.134.5.180 45750 443 6 10 1500 1617877020 1617877078 ACCEPT OK"},
+			{Timestamp: 1617877079000, Message: "2 197152445587 eni-16105f5a 167.71.2.190 10.134.5.180 60462 22 6 2 88 1617877020 1617877078 REJECT OK"},
+			{Timestamp: 1617877139000, Message: "2 197152445587 eni-16105f5a 167.71.2.190 10.134.5.180 60470 22 6 1 44 1617877079 1617877138 REJECT OK"},
+			{Timestamp: 1617877139000, Message: "2 197152445587 eni-16105f5a - - - - - - - 1617877079 1617877138 - NODATA"},
+		},
+	}
+
+	t.Run("all flows", func(t *testing.T) {
+		logs := parseCloudWatchLogs(cloudWatchEventFor(data))
+
+		assert.Equal(t, 3, len(logs))
+		assert.Equal(t, "2 197152445587 eni-16105f5a - - - - - - - 1617877079 1617877138 - NODATA", logs[0].Message)
+		assert.Equal(t, "10.134.2.231 10.134.5.180 443 6 ACCEPT 2 76 25001 1617877020 1617877138", logs[1].Message)
+		assert.Equal(t, time.Unix(1617877079, 0), logs[1].Timestamp)
+		assert.Equal(t, map[string]string{"system.aws.networkInterfaceId": "eni-16105f5a"}, logs[1].ResourceID)
+		assert.Equal(t, map[string]string{
+			"srcaddr":  "10.134.2.231",
+			"dstaddr":  "10.134.5.180",
+			"dstport":  "443",
+			"protocol": "6",
+			"action":   "ACCEPT",
+			"flows":    "2",
+			"packets":  "76",
+			"bytes":    "25001",
+			"start":    "1617877020",
+			"end":      "1617877138",
+		}, logs[1].Metadata)
+		assert.Equal(t, "167.71.2.190 10.134.5.180 22 6 REJECT 2 3 132 1617877020 1617877138", logs[2].Message)
+	})
+
+	t.Run("keep rejected flows", func(t *testing.T) {
+		flowLogKeepRejected = true
+
+		logs := parseCloudWatchLogs(cloudWatchEventFor(data))
+
+		assert.Equal(t, 4, len(logs))
+		assert.Equal(t, "2 197152445587 eni-16105f5a 167.71.2.190 10.134.5.180 60462 22 6 2 88 1617877020 1617877078 REJECT OK", logs[0].Message)
+		assert.Equal(t, "2 197152445587 eni-16105f5a 167.71.2.190 10.134.5.180 60470 22 6 1 44 1617877079 1617877138 REJECT OK", logs[1].Message)
+		assert.Equal(t, "10.134.2.231 10.134.5.180 443 6 ACCEPT 2 76 25001 1617877020 1617877138", logs[3].Message)
+	})
+}
@@ -4,8 +4,9 @@ go 1.14
 
 require (
 	github.com/aws/aws-lambda-go v1.19.1
-	github.com/aws/aws-sdk-go v1.35.14
-	github.com/google/uuid v1.1.2 // indirect
+	github.com/aws/aws-sdk-go v1.38.0
+	github.com/fraugster/parquet-go v0.12.0
+	github.com/google/uuid v1.1.2
 	github.com/logicmonitor/lm-logs-sdk-go v0.0.0-20210301071118-44b910823a84
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.7.0
 )
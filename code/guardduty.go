@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// guardDutyS3KeyRegex matches the keys of findings GuardDuty exports to S3.
+var guardDutyS3KeyRegex = regexp.MustCompile(`AWSLogs/(?P<account>\d{12})/GuardDuty/(?P<region>[a-z0-9-]+)/`)
+
+// guardDutyFinding holds the fields of a GuardDuty finding promoted to
+// attributes or used to find the affected resource.
+type guardDutyFinding struct {
+	AccountID string   `json:"accountId"`
+	Region    string   `json:"region"`
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Title     string   `json:"title"`
+	Severity  *float64 `json:"severity"`
+	UpdatedAt string   `json:"updatedAt"`
+	Resource  struct {
+		ResourceType    string `json:"resourceType"`
+		InstanceDetails struct {
+			InstanceID string `json:"instanceId"`
+		} `json:"instanceDetails"`
+		AccessKeyDetails struct {
+			AccessKeyID string `json:"accessKeyId"`
+			UserName    string `json:"userName"`
+			UserType    string `json:"userType"`
+		} `json:"accessKeyDetails"`
+		S3BucketDetails []struct {
+			ARN  string `json:"arn"`
+			Name string `json:"name"`
+		} `json:"s3BucketDetails"`
+		EKSClusterDetails struct {
+			ARN  string `json:"arn"`
+			Name string `json:"name"`
+		} `json:"eksClusterDetails"`
+	} `json:"resource"`
+}
+
+// isGuardDutyEvent reports whether an EventBridge event carries a GuardDuty
+// finding.
+func isGuardDutyEvent(data map[string]interface{}) bool {
+	return data["source"] == "aws.guardduty" && data["detail-type"] == "GuardDuty Finding"
+}
+
+// parseGuardDutyEvent forwards the finding of a GuardDuty Finding event
+// delivered by EventBridge, with the attributes of other EventBridge events.
+// The attributes of the finding take precedence, so id is the finding's as
+// for exported findings.
+func parseGuardDutyEvent(event events.CloudWatchEvent) []LMLog {
+	lmEv := parseGuardDutyFinding(string(event.Detail), event.AccountID, event.Time)
+
+	metadata := eventBridgeAttributes(event)
+	for key, value := range lmEv.Metadata {
+		metadata[key] = value
+	}
+	lmEv.Metadata = metadata
+
+	lmBatch := make([]LMLog, 0)
+	lmBatch = append(lmBatch, lmEv)
+	return lmBatch
+}
+
+// parseGuardDutyS3Logs splits a findings file GuardDuty exported to S3 into
+// one event per finding.
+func parseGuardDutyS3Logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	bucketName := request.Records[0].S3.Bucket.Name
+	key := request.Records[0].S3.Object.Key
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
+	}
+
+	var owner string
+	if keyMatches := guardDutyS3KeyRegex.FindStringSubmatch(key); keyMatches != nil {
+		owner = keyMatches[guardDutyS3KeyRegex.SubexpIndex("account")]
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lmBatch = append(lmBatch, parseGuardDutyFinding(line, owner, request.Records[0].EventTime))
+	}
+	return lmBatch, nil
+}
+
+// parseGuardDutyFinding returns the event of a GuardDuty finding, timestamped
+// with its last update and attached to the affected resource. Findings that
+// cannot be decoded are sent as they are against the account.
+func parseGuardDutyFinding(message string, owner string, deliveryTime time.Time) LMLog {
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:    message,
+			ResourceID: accountResourceID(resolveAccountID(owner)),
+			Timestamp:  deliveryTime,
+		},
+	}
+
+	var finding guardDutyFinding
+	err := json.Unmarshal([]byte(message), &finding)
+	if err != nil {
+		fmt.Printf("WARN failed to parse guardduty finding: %s\n", err)
+		return lmEv
+	}
+
+	if updatedAt, err := time.Parse(time.RFC3339, finding.UpdatedAt); err == nil {
+		lmEv.Timestamp = updatedAt
+	}
+	lmEv.ResourceID = guardDutyResourceID(finding, owner)
+	lmEv.Metadata = guardDutyAttributes(finding)
+	return lmEv
+}
+
+func guardDutyAttributes(finding guardDutyFinding) map[string]string {
+	fields := map[string]string{
+		"id":                                    finding.ID,
+		"type":                                  finding.Type,
+		"title":                                 finding.Title,
+		"resource.resourceType":                 finding.Resource.ResourceType,
+		"resource.instanceDetails.instanceId":   finding.Resource.InstanceDetails.InstanceID,
+		"resource.accessKeyDetails.accessKeyId": finding.Resource.AccessKeyDetails.AccessKeyID,
+		"resource.accessKeyDetails.userName":    finding.Resource.AccessKeyDetails.UserName,
+		"resource.eksClusterDetails.name":       finding.Resource.EKSClusterDetails.Name,
+	}
+	if finding.Severity != nil {
+		fields["severity"] = strconv.FormatFloat(*finding.Severity, 'f', -1, 64)
+	}
+	if len(finding.Resource.S3BucketDetails) > 0 {
+		fields["resource.s3BucketDetails.name"] = finding.Resource.S3BucketDetails[0].Name
+	}
+	return attributes(fields)
+}
+
+// guardDutyResourceID maps a finding to the resource it affects: an EC2
+// instance, the IAM user or role of an access key, an S3 bucket or an EKS
+// cluster. Other findings are attached to the account.
+func guardDutyResourceID(finding guardDutyFinding, owner string) map[string]string {
+	region := resolveRegion(finding.Region)
+	accountID := resolveAccountID(finding.AccountID, owner)
+	resource := finding.Resource
+
+	var arn string
+	var err error
+	switch resource.ResourceType {
+	case "Instance":
+		if instanceID := resource.InstanceDetails.InstanceID; instanceID != "" {
+			arn, err = buildARN("ec2", region, accountID, "instance/"+instanceID)
+		}
+	case "AccessKey":
+		if userName := resource.AccessKeyDetails.UserName; userName != "" {
+			switch resource.AccessKeyDetails.UserType {
+			case "IAMUser":
+				arn, err = buildGlobalARN("iam", region, accountID, "user/"+userName)
+			case "AssumedRole":
+				arn, err = buildGlobalARN("iam", region, accountID, "role/"+userName)
+			}
+		}
+	case "S3Bucket":
+		if len(resource.S3BucketDetails) > 0 {
+			bucket := resource.S3BucketDetails[0]
+			if arn = bucket.ARN; validateARN(arn) != nil && bucket.Name != "" {
+				arn, err = buildGlobalARN("s3", region, "", bucket.Name)
+			}
+		}
+	case "EKSCluster":
+		cluster := resource.EKSClusterDetails
+		if arn = cluster.ARN; validateARN(arn) != nil && cluster.Name != "" {
+			arn, err = buildARN("eks", region, accountID, "cluster/"+cluster.Name)
+		}
+	}
+
+	if arn == "" || err != nil || validateARN(arn) != nil {
+		return accountResourceID(accountID)
+	}
+	return map[string]string{"system.aws.arn": arn}
+}
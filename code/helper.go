@@ -5,6 +5,9 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func ExtractEnvironmentVariables() {
@@ -35,11 +38,92 @@ func ExtractEnvironmentVariables() {
 
 	scrubRegex = os.Getenv("LM_SCRUB_REGEX")
 
+	var err error
+	multilineRules, err = parseMultilineRules(os.Getenv("LM_MULTILINE_RULES"))
+	handleFatalError("invalid LM_MULTILINE_RULES", err)
+
+	resourceMappingRules, err = parseResourceMappingRules(os.Getenv("LM_RESOURCE_MAPPING_RULES"))
+	handleFatalError("invalid LM_RESOURCE_MAPPING_RULES", err)
+
+	flowLogFormats, err = parseFlowLogFormats(os.Getenv("LM_FLOW_LOG_FORMATS"))
+	handleFatalError("invalid LM_FLOW_LOG_FORMATS", err)
+	flowLogAggregation = os.Getenv("LM_FLOW_LOG_AGGREGATION") == "true"
+	flowLogKeepRejected = os.Getenv("LM_FLOW_LOG_KEEP_REJECTED") == "true"
+
+	resourceTagKeys = parseCommaSeparated(os.Getenv("LM_RESOURCE_TAGS"))
+	if ttl := os.Getenv("LM_RESOURCE_TAGS_CACHE_TTL"); ttl != "" {
+		seconds, err := strconv.Atoi(ttl)
+		handleFatalError("invalid LM_RESOURCE_TAGS_CACHE_TTL", err)
+		resourceTagsCacheTTL = time.Duration(seconds) * time.Second
+	}
+
+	cloudFrontRealtimeLogFields, err = parseCloudFrontRealtimeLogFields(os.Getenv("LM_CLOUDFRONT_REALTIME_LOG_FIELDS"))
+	handleFatalError("invalid LM_CLOUDFRONT_REALTIME_LOG_FIELDS", err)
+
+	cloudTrailDigestValidation = os.Getenv("LM_CLOUDTRAIL_DIGEST_VALIDATION") == "true"
+
+	if secretArn := os.Getenv("LM_FIREHOSE_ACCESS_KEY_ARN"); secretArn != "" {
+		firehoseAccessKey = getSecretValue(secretArn)
+	}
+	httpListenAddress = os.Getenv("LM_HTTP_LISTEN_ADDRESS")
+	firehoseNormalizeRecords = os.Getenv("LM_FIREHOSE_NORMALIZE_RECORDS") == "true"
+
+	wafDropHeaders = os.Getenv("LM_WAF_DROP_HEADERS") == "true"
+	wafRedactHeaders = parseCommaSeparated(os.Getenv("LM_WAF_REDACT_HEADERS"))
+
+	resolverQueryLogGroups = parseCommaSeparated(os.Getenv("LM_RESOLVER_QUERY_LOG_GROUPS"))
+	apiGatewayAccessLogGroups = parseCommaSeparated(os.Getenv("LM_API_GATEWAY_ACCESS_LOG_GROUPS"))
+
 	logSource = "lm-logs-aws"
 
 	versionID = "0.0.1"
 }
 
+// parseCommaSeparated reads a comma separated list, such as tag keys or log
+// group prefixes, leaving out empty entries.
+func parseCommaSeparated(config string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(config, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// hasLogGroupPrefix reports whether a log group starts with one of the given
+// names or prefixes.
+func hasLogGroupPrefix(logGroup string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(logGroup, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRegion returns the first region found in the event, falling back to
+// the region the forwarder runs in.
+func resolveRegion(regions ...string) string {
+	for _, region := range regions {
+		if region != "" {
+			return region
+		}
+	}
+	return awsRegion
+}
+
+// resolveAccountID returns the first account found in the event, falling back
+// to the account the forwarder runs in.
+func resolveAccountID(accountIDs ...string) string {
+	for _, accountID := range accountIDs {
+		if accountID != "" {
+			return accountID
+		}
+	}
+	return awsAccountID
+}
+
 func readCloserToString(body io.ReadCloser) string {
 	buf := new(bytes.Buffer)
 	_, _ = buf.ReadFrom(body)
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// isKinesisEvent reports whether the records of an invocation were read from
+// a Kinesis data stream.
+func isKinesisEvent(records interface{}) bool {
+	recordList, ok := records.([]interface{})
+	if !ok || len(recordList) == 0 {
+		return false
+	}
+	record, ok := recordList[0].(map[string]interface{})
+	return ok && record["eventSource"] == "aws:kinesis"
+}
+
+// cloudWatchLogsControlMessage is the message type of the records CloudWatch
+// Logs writes to check that a subscription destination is reachable.
+const cloudWatchLogsControlMessage = "CONTROL_MESSAGE"
+
+// parseKinesisLogs reads the records of a Kinesis Data Streams invocation.
+// CloudFront real-time log records are parsed with the configured fields, and
+// other records are read with parseStreamRecord.
+func parseKinesisLogs(request events.KinesisEvent) []LMLog {
+	lmBatch := make([]LMLog, 0)
+
+	for _, record := range request.Records {
+		if len(cloudFrontRealtimeLogFields) > 0 && !isGzipData(record.Kinesis.Data) {
+			accountID := resolveAccountID(streamAccountID(record.EventSourceArn))
+			if lmEv, ok := parseCloudFrontRealtimeRecord(record, accountID); ok {
+				lmBatch = append(lmBatch, lmEv)
+				continue
+			}
+		}
+
+		lmBatch = append(lmBatch, parseStreamRecord(record.Kinesis.Data, record.EventSourceArn, record.Kinesis.ApproximateArrivalTimestamp.Time)...)
+	}
+	return lmBatch
+}
+
+// parseStreamRecord reads a record delivered by Kinesis Data Streams or
+// Firehose. Gzipped CloudWatch Logs subscription payloads are mapped like
+// direct subscriptions, records of WAF delivery streams are parsed as WAF
+// logs, and other records are sent as they are against the stream they were
+// read from.
+func parseStreamRecord(data []byte, streamARN string, timestamp time.Time) []LMLog {
+	if isGzipData(data) {
+		logs, err := parseCloudWatchLogsRecord(data, streamRegion(streamARN))
+		if err != nil {
+			fmt.Printf("WARN failed to parse record from %s: %s\n", streamARN, err)
+		}
+		return logs
+	}
+
+	message := strings.TrimRight(string(data), "\r\n")
+	if strings.TrimSpace(message) == "" {
+		return nil
+	}
+	if isWAFDeliveryStream(streamARN) {
+		if lmEv, ok := parseWAFLog(message, streamAccountID(streamARN), timestamp); ok {
+			return []LMLog{lmEv}
+		}
+		return nil
+	}
+	return []LMLog{{
+		Log: ingest.Log{
+			Message:    message,
+			ResourceID: streamResourceID(streamARN),
+			Timestamp:  timestamp,
+		},
+	}}
+}
+
+func isGzipData(data []byte) bool {
+	return http.DetectContentType(data) == "application/x-gzip"
+}
+
+// parseCloudWatchLogsRecord decodes a record holding a gzipped CloudWatch Logs
+// subscription payload read from a stream of the given region, skipping
+// control messages.
+func parseCloudWatchLogsRecord(data []byte, region string) ([]LMLog, error) {
+	content, err := gunzip(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var d events.CloudwatchLogsData
+	err = json.Unmarshal([]byte(content), &d)
+	if err != nil {
+		return nil, err
+	}
+	if d.MessageType == cloudWatchLogsControlMessage {
+		return nil, nil
+	}
+	return parseCloudWatchLogsData(d, region), nil
+}
+
+// streamRegion returns the region of a Kinesis stream or Firehose delivery
+// stream ARN.
+func streamRegion(streamARN string) string {
+	parts := strings.SplitN(streamARN, ":", 6)
+	if len(parts) != 6 {
+		return ""
+	}
+	return parts[3]
+}
+
+// streamAccountID returns the account of a Kinesis stream or Firehose
+// delivery stream ARN.
+func streamAccountID(streamARN string) string {
+	parts := strings.SplitN(streamARN, ":", 6)
+	if len(parts) != 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// streamResourceID maps records to the stream they were read from, or to the
+// account when the stream ARN is invalid.
+func streamResourceID(streamARN string) map[string]string {
+	if err := validateARN(streamARN); err != nil {
+		return accountResourceID(resolveAccountID(streamAccountID(streamARN)))
+	}
+	return map[string]string{"system.aws.arn": streamARN}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func kinesisEventFor(data ...string) events.KinesisEvent {
+	arrivalTime := time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC)
+	event := events.KinesisEvent{}
+	for _, record := range data {
+		event.Records = append(event.Records, events.KinesisEventRecord{
+			AwsRegion:      "ap-northeast-1",
+			EventSource:    "aws:kinesis",
+			EventSourceArn: "arn:aws:kinesis:ap-northeast-1:197152445587:stream/cdn-logs",
+			Kinesis: events.KinesisRecord{
+				ApproximateArrivalTimestamp: events.SecondsEpochTime{Time: arrivalTime},
+				Data:                        []byte(record),
+			},
+		})
+	}
+	return event
+}
+
+func TestParseEventTypeKinesis(t *testing.T) {
+	event := map[string]interface{}{
+		"Records": []interface{}{
+			map[string]interface{}{
+				"eventSource":    "aws:kinesis",
+				"eventSourceARN": "arn:aws:kinesis:ap-northeast-1:197152445587:stream/cdn-logs",
+				"kinesis":        map[string]interface{}{"data": base64.StdEncoding.EncodeToString([]byte("hello"))},
+			},
+		},
+	}
+
+	assert.Equal(t, "kinesis", ParseEventType(event))
+	assert.Equal(t, "hello", string(convertToKinesisEvent(event).Records[0].Kinesis.Data))
+}
+
+func TestParseKinesisCloudFrontRealtimeLogs(t *testing.T) {
+	fields, err := parseCloudFrontRealtimeLogFields("timestamp, c-ip, sc-status, cs-method, cs-uri-stem, primary-distribution-id")
+	assert.NoError(t, err)
+	cloudFrontRealtimeLogFields = fields
+	defer func() { cloudFrontRealtimeLogFields = nil }()
+
+	lmEvents := parseKinesisLogs(kinesisEventFor(
+		"1619416294.250\t192.0.2.100\t200\tGET\t/index.html\tE2QWRUHAPOMQZL\n",
+		"1619416295.000\t192.0.2.101\t404\tGET\t/missing.html\t-\n",
+		"not a real-time log record\n",
+	))
+
+	assert.Equal(t, 3, len(lmEvents))
+	assert.Equal(t, "1619416294.250\t192.0.2.100\t200\tGET\t/index.html\tE2QWRUHAPOMQZL", lmEvents[0].Message)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:cloudfront::197152445587:distribution/E2QWRUHAPOMQZL"}, lmEvents[0].ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 51, 34, 250000000, time.UTC), lmEvents[0].Timestamp.UTC())
+	assert.Equal(t, map[string]string{
+		"timestamp":               "1619416294.250",
+		"c-ip":                    "192.0.2.100",
+		"sc-status":               "200",
+		"cs-method":               "GET",
+		"cs-uri-stem":             "/index.html",
+		"primary-distribution-id": "E2QWRUHAPOMQZL",
+	}, lmEvents[0].Metadata)
+
+	assert.Equal(t, accountResourceID("197152445587"), lmEvents[1].ResourceID)
+	assert.Equal(t, "404", lmEvents[1].Metadata["sc-status"])
+
+	assert.Equal(t, "not a real-time log record", lmEvents[2].Message)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:kinesis:ap-northeast-1:197152445587:stream/cdn-logs"}, lmEvents[2].ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 50, 0, 0, time.UTC), lmEvents[2].Timestamp.UTC())
+	assert.Nil(t, lmEvents[2].Metadata)
+}
+
+func TestParseCloudFrontRealtimeLogFields(t *testing.T) {
+	fields, err := parseCloudFrontRealtimeLogFields("")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	_, err = parseCloudFrontRealtimeLogFields("timestamp,${c-ip}")
+	assert.Error(t, err)
+}
+
+func TestParseKinesisCloudWatchLogs(t *testing.T) {
+	awsRegion = "ap-northeast-1"
+	defer func() { awsRegion = "" }()
+
+	control, _ := json.Marshal(events.CloudwatchLogsData{
+		MessageType: "CONTROL_MESSAGE",
+		Owner:       "CloudwatchLogs",
+		LogEvents:   []events.CloudwatchLogsLogEvent{{Message: "CWL CONTROL MESSAGE: Checking health of destination Kinesis stream."}},
+	})
+	data, _ := json.Marshal(events.CloudwatchLogsData{
+		MessageType: "DATA_MESSAGE",
+		Owner:       "197152445587",
+		LogGroup:    "/aws/lambda/checkout",
+		LogStream:   "2021/04/26/[$LATEST]0123456789abcdef",
+		LogEvents: []events.CloudwatchLogsLogEvent{
+			{ID: "1", Timestamp: 1619416294250, Message: "START RequestId: 6e8b7a4c"},
+			{ID: "2", Timestamp: 1619416294300, Message: "END RequestId: 6e8b7a4c"},
+		},
+	})
+
+	lmEvents := parseKinesisLogs(kinesisEventFor(gzipString(string(control)), gzipString(string(data))))
+
+	assert.Equal(t, 2, len(lmEvents))
+	assert.Equal(t, "START RequestId: 6e8b7a4c", lmEvents[0].Message)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:lambda:ap-northeast-1:197152445587:function:checkout"}, lmEvents[0].ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 51, 34, 250000000, time.UTC), lmEvents[0].Timestamp.UTC())
+	assert.Equal(t, "END RequestId: 6e8b7a4c", lmEvents[1].Message)
+}
+
+func TestParseKinesisCloudWatchLogsStreamRegion(t *testing.T) {
+	awsRegion = "ap-northeast-1"
+	defer func() { awsRegion = "" }()
+
+	data, _ := json.Marshal(events.CloudwatchLogsData{
+		MessageType: "DATA_MESSAGE",
+		Owner:       "197152445587",
+		LogGroup:    "/aws/lambda/checkout",
+		LogStream:   "2021/04/26/[$LATEST]0123456789abcdef",
+		LogEvents:   []events.CloudwatchLogsLogEvent{{ID: "1", Timestamp: 1619416294250, Message: "START RequestId: 6e8b7a4c"}},
+	})
+
+	lmEvents := parseStreamRecord([]byte(gzipString(string(data))), "arn:aws:kinesis:eu-west-1:197152445587:stream/logs", time.Time{})
+
+	assert.Equal(t, 1, len(lmEvents))
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:lambda:eu-west-1:197152445587:function:checkout"}, lmEvents[0].ResourceID)
+}
+
+func TestParseKinesisCorruptGzipRecord(t *testing.T) {
+	data, _ := json.Marshal(events.CloudwatchLogsData{
+		MessageType: "DATA_MESSAGE",
+		Owner:       "197152445587",
+		LogGroup:    "/aws/lambda/checkout",
+		LogEvents:   []events.CloudwatchLogsLogEvent{{ID: "1", Timestamp: 1619416294250, Message: "START RequestId: 6e8b7a4c"}},
+	})
+	compressed := gzipString(string(data))
+
+	lmEvents := parseKinesisLogs(kinesisEventFor(compressed[:len(compressed)/2], "\x1f\x8b\x08corrupt", "plain record\n"))
+
+	assert.Equal(t, 1, len(lmEvents))
+	assert.Equal(t, "plain record", lmEvents[0].Message)
+}
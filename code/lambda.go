@@ -1,19 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"regexp"
 	"strings"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
 )
 
-var lmHost, awsRegion, scrubRegex, logSource, versionID string
+var lmHost, awsRegion, awsAccountID, scrubRegex, logSource, versionID string
 var accessID, accessKey, companyName string
 var debug bool
 
@@ -27,10 +31,23 @@ func getCompany() string {
 	return result[1]
 }
 
-func SendLogs(logs []ingest.Log) {
+// logsNotAcceptedError is returned by SendLogs when LM Logs answers the
+// request but does not accept its logs. Some of them may have been ingested,
+// so only triggers that cannot lose a batch deliver it again.
+type logsNotAcceptedError struct {
+	message string
+}
+
+func (e *logsNotAcceptedError) Error() string {
+	return fmt.Sprintf("logs were not accepted: %s", e.message)
+}
+
+// SendLogs posts logs to LM Logs, returning an error when the request fails
+// or is not accepted so the caller can have them delivered again.
+func SendLogs(logs []LMLog) error {
 
 	if len(logs) == 0 {
-		return
+		return nil
 	}
 
 	lmIngest := ingest.Ingest{
@@ -42,17 +59,23 @@ func SendLogs(logs []ingest.Log) {
 	}
 
 	// Send logs to Logic Monitor
-	ingestResponse, err := lmIngest.SendLogs(logs)
-	handleFatalError("Request failed", err)
+	ingestResponse, err := sendToIngest(lmIngest, logs)
+	if err != nil {
+		return fmt.Errorf("request failed: %s", err)
+	}
 
 	if debug || !ingestResponse.Success {
 		json, _ := json.Marshal(ingestResponse)
 		fmt.Printf("Response: %s\n", string(json))
 		fmt.Println(string(json))
 	}
+	if !ingestResponse.Success {
+		return &logsNotAcceptedError{message: ingestResponse.Message}
+	}
+	return nil
 }
 
-func ScrubLogsWithRegex(lmBatch []ingest.Log) {
+func ScrubLogsWithRegex(lmBatch []LMLog) {
 	if scrubRegex != "" {
 		reg := regexp.MustCompile(scrubRegex)
 		for _, event := range lmBatch {
@@ -71,20 +94,62 @@ func ParseEventType(requests interface{}) string {
 		return "cloudwatch"
 	}
 
-	_, ok = data["Records"]
+	records, ok := data["Records"]
 	if ok {
-		event := convertToS3Event(requests)
-		if strings.Contains(event.Records[0].S3.Object.Key, "elasticloadbalancing") {
-			return "elb"
+		if isKinesisEvent(records) {
+			return "kinesis"
+		}
+		return s3EventType(convertToS3Event(requests))
+	}
+	if isEventBridgeEvent(data) {
+		if isEventBridgeS3Event(data) {
+			return s3EventType(convertToS3Event(requests))
+		}
+		if isGuardDutyEvent(data) {
+			return "guardduty-event"
 		}
-		return "s3"
+		return "eventbridge"
 	}
 	log.Fatalf("Could not extract event type")
 	return ""
 }
 
-func ExtractLogs(data interface{}) []ingest.Log {
-	logs := []ingest.Log{}
+// s3EventType returns the parser of the object an S3 notification is about,
+// by the shape of its key.
+func s3EventType(event events.S3Event) string {
+	key := event.Records[0].S3.Object.Key
+	if keyMatches := cloudTrailS3KeyRegex.FindStringSubmatch(key); keyMatches != nil {
+		switch keyMatches[cloudTrailS3KeyRegex.SubexpIndex("type")] {
+		case "-Digest":
+			return "cloudtrail-digest"
+		case "-Insight":
+			return "cloudtrail-insight"
+		}
+		return "cloudtrail"
+	}
+	if cloudFrontS3KeyRegex.MatchString(key) {
+		return "cloudfront"
+	}
+	if guardDutyS3KeyRegex.MatchString(key) {
+		return "guardduty"
+	}
+	if wafS3KeyRegex.MatchString(key) {
+		return "waf"
+	}
+	if resolverQueryLogS3KeyRegex.MatchString(key) {
+		return "resolverquerylogs"
+	}
+	if vpcFlowLogS3KeyRegex.MatchString(key) {
+		return "vpcflowlogs"
+	}
+	if strings.Contains(key, "elasticloadbalancing") {
+		return "elb"
+	}
+	return "s3"
+}
+
+func ExtractLogs(data interface{}) []LMLog {
+	logs := []LMLog{}
 	var err error
 	source := ParseEventType(data)
 
@@ -107,19 +172,105 @@ func ExtractLogs(data interface{}) []ingest.Log {
 		if err != nil {
 			fmt.Printf("WARN failed to parse elb logs %s\n", err)
 		}
+	case "vpcflowlogs":
+		s3Event := convertToS3Event(data)
+		logs, err = parseFlowLogS3Logs(s3Event, getContentsFromS3Bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to parse flow logs %s\n", err)
+		}
+	case "cloudfront":
+		s3Event := convertToS3Event(data)
+		logs, err = parseCloudFrontLogs(s3Event, getContentsFromS3Bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to parse cloudfront logs %s\n", err)
+		}
+	case "eventbridge":
+		eventBridgeEvent := convertToEventBridgeEvent(data)
+		logs, err = parseEventBridgeEvent(eventBridgeEvent)
+		if err != nil {
+			fmt.Printf("WARN failed to parse eventbridge event %s\n", err)
+		}
+	case "guardduty":
+		s3Event := convertToS3Event(data)
+		logs, err = parseGuardDutyS3Logs(s3Event, getContentsFromS3Bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to parse guardduty findings %s\n", err)
+		}
+	case "guardduty-event":
+		eventBridgeEvent := convertToEventBridgeEvent(data)
+		logs = parseGuardDutyEvent(eventBridgeEvent)
+	case "waf":
+		s3Event := convertToS3Event(data)
+		logs, err = parseWAFS3Logs(s3Event, getContentsFromS3Bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to parse waf logs %s\n", err)
+		}
+	case "resolverquerylogs":
+		s3Event := convertToS3Event(data)
+		logs, err = parseResolverQueryLogS3Logs(s3Event, getContentsFromS3Bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to parse resolver query logs %s\n", err)
+		}
+	case "kinesis":
+		kinesisEvent := convertToKinesisEvent(data)
+		logs = parseKinesisLogs(kinesisEvent)
+	case "cloudtrail":
+		s3Event := convertToS3Event(data)
+		logs, err = parseCloudTrailS3Logs(s3Event, getContentsFromS3Bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to parse cloudtrail logs %s\n", err)
+		}
+	case "cloudtrail-insight":
+		s3Event := convertToS3Event(data)
+		logs, err = parseCloudTrailInsightS3Logs(s3Event, getContentsFromS3Bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to parse cloudtrail insight logs %s\n", err)
+		}
+	case "cloudtrail-digest":
+		if !cloudTrailDigestValidation {
+			break
+		}
+		s3Event := convertToS3Event(data)
+		logs, err = parseCloudTrailDigest(s3Event, getContentsFromS3Bucket, getS3ObjectMetadata, getCloudTrailPublicKeys)
+		if err != nil {
+			fmt.Printf("WARN failed to validate cloudtrail digest %s\n", err)
+		}
 	}
 	return logs
 }
 
 // Lambda handler
-func handler(request interface{}) {
-	logs := ExtractLogs(request)
-	ScrubLogsWithRegex(logs)
-	SendLogs(logs)
+func handler(ctx context.Context, request interface{}) (interface{}, error) {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		if functionArn := strings.Split(lc.InvokedFunctionArn, ":"); len(functionArn) > 4 {
+			awsAccountID = functionArn[4]
+		}
+	}
+
+	if isFunctionURLRequest(request) {
+		return handleFunctionURLRequest(convertToFunctionURLRequest(request), forwardLogs), nil
+	}
+	if isFirehoseTransformationEvent(request) {
+		return transformFirehoseRecords(convertToFirehoseEvent(request), forwardLogs)
+	}
+
+	// Retrying S3, CloudWatch Logs or Kinesis invocations would send the logs
+	// LM Logs accepted again, so logs it does not accept are only reported.
+	err := forwardLogs(ExtractLogs(request))
+	var notAccepted *logsNotAcceptedError
+	if errors.As(err, &notAccepted) {
+		fmt.Printf("WARN %s\n", err)
+		return nil, nil
+	}
+	return nil, err
 }
 
 func main() {
 	ExtractEnvironmentVariables()
 	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: false}
+	if httpListenAddress != "" {
+		log.Printf("Listening for Firehose deliveries on %s", httpListenAddress)
+		log.Fatal(http.ListenAndServe(httpListenAddress, firehoseHTTPHandler(forwardLogs)))
+	}
 	lambda.Start(handler)
 }
@@ -110,3 +110,44 @@ func TestParseEventTypeS3(t *testing.T) {
 
 	assert.Equal(t, "s3", ParseEventType(event))
 }
+
+func TestResolveAccountID(t *testing.T) {
+	awsAccountID = "197152445587"
+	defer func() { awsAccountID = "" }()
+
+	assert.Equal(t, "222222222222", resolveAccountID("", "222222222222"))
+	assert.Equal(t, "197152445587", resolveAccountID(""))
+}
+
+func TestParseEventTypeS3Keys(t *testing.T) {
+	keys := map[string]string{
+		"AWSLogs/197152445587/CloudTrail/ap-northeast-1/2021/04/26/197152445587_CloudTrail_ap-northeast-1_20210426T0550Z_xQQbx1hRL6YkH4mF.json.gz":           "cloudtrail",
+		"prefix/AWSLogs/o-a1b2c3d4e5/197152445587/CloudTrail/us-east-1/2021/04/26/197152445587_CloudTrail_us-east-1_20210426T0550Z_xQQbx1hRL6YkH4mF.json.gz": "cloudtrail",
+		"AWSLogs/197152445587/CloudTrail-Digest/us-east-1/2021/04/26/197152445587_CloudTrail-Digest_us-east-1_trail_us-east-1_20210426T055000Z.json.gz":      "cloudtrail-digest",
+		"AWSLogs/197152445587/CloudTrail-Insight/us-east-1/2021/04/26/197152445587_CloudTrail-Insight_us-east-1_20210426T0550Z_xQQbx1hRL6YkH4mF.json.gz":     "cloudtrail-insight",
+		"AWSLogs/197152445587/vpcflowlogs/us-east-1/2021/04/26/197152445587_vpcflowlogs_us-east-1_fl-0a1b2c3d4e5f67890_20210426T0550Z_a1b2c3d4.log.gz":       "vpcflowlogs",
+		"AWSLogs/aws-account-id=197152445587/aws-service=vpcflowlogs/aws-region=us-east-1/year=2021/month=04/day=26/hour=05/a.log.parquet":                   "vpcflowlogs",
+		"cloudfront/E2QWRUHAPOMQZL.2021-04-26-05.a1b2c3d4.gz":                                                                                                        "cloudfront",
+		"EMLARXS9EXAMPLE.2019-11-14-20.RT4KCN4SGK9.gz":                                                                                                               "cloudfront",
+		"AWSLogs/197152445587/GuardDuty/ap-northeast-1/2021/04/26/5a5c8b2c-1e0f-3a8b-9d3e-4b7c2f1a0e9d.jsonl.gz":                                                     "guardduty",
+		"AWSLogs/197152445587/WAFLogs/ap-northeast-1/storefront/2021/04/26/05/50/197152445587_waflogs_ap-northeast-1_storefront_20210426T0550Z_a1b2c3d4.log.gz":      "waf",
+		"waf/2021/04/26/05/aws-waf-logs-storefront-1-2021-04-26-05-50-00-a1b2c3d4-e5f6-7890-abcd-ef0123456789":                                                       "waf",
+		"AWSLogs/197152445587/vpcdnsquerylogs/vpc-0a1b2c3d4e5f67890/2021/04/26/vpc-0a1b2c3d4e5f67890_vpcdnsquerylogs_197152445587_20210426T0550Z_a1b2c3d4.log.gz":    "resolverquerylogs",
+		"AWSLogs/197152445587/elasticloadbalancing/us-east-1/2021/04/26/197152445587_elasticloadbalancing_us-east-1_app.test.50dc6c495c0c9188_20210426T0550Z.log.gz": "elb",
+	}
+	for key, eventType := range keys {
+		event := map[string]interface{}{
+			"Records": []interface{}{
+				map[string]interface{}{
+					"eventSource": "aws:s3",
+					"s3": map[string]interface{}{
+						"bucket": map[string]interface{}{"name": "cloudtrail-bucket"},
+						"object": map[string]interface{}{"key": key},
+					},
+				},
+			},
+		}
+
+		assert.Equal(t, eventType, ParseEventType(event), key)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/logicmonitor/lm-logs-sdk-go/apitoken"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// LMLog is a log event bound for LM Logs. Metadata entries are sent as
+// attributes of the event, next to its message, timestamp and resource.
+type LMLog struct {
+	ingest.Log
+	Metadata map[string]string
+}
+
+func (l LMLog) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{}, len(l.Metadata)+3)
+	for key, value := range l.Metadata {
+		fields[key] = value
+	}
+	fields["msg"] = l.Message
+	fields["timestamp"] = l.Timestamp
+	fields["_lm.resourceId"] = l.ResourceID
+	return json.Marshal(fields)
+}
+
+// attributes returns the fields that have a value, to be sent as the metadata
+// of an event.
+func attributes(fields map[string]string) map[string]string {
+	metadata := make(map[string]string)
+	for key, value := range fields {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// sendToIngest posts logs to the LM Logs ingest endpoint the same way
+// ingest.Ingest.SendLogs does, keeping the metadata of each event.
+func sendToIngest(lmIngest ingest.Ingest, logs []LMLog) (*ingest.Response, error) {
+	url := fmt.Sprintf("https://%s.logicmonitor.com/rest/log/ingest", lmIngest.CompanyName)
+
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	lMv1Token := apitoken.GenerateLMv1Token(lmIngest.AccessID, lmIngest.AccessKey, body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", lMv1Token.String())
+	req.Header.Set("User-Agent", lmIngest.LogSource+"/"+lmIngest.VersionID)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	ingestResponse := &ingest.Response{}
+	err = json.Unmarshal(respBody, ingestResponse)
+	if err != nil {
+		ingestResponse.Success = false
+		ingestResponse.Message = fmt.Sprintf("Invalid Response! , Status Code: %d , Body: %s", resp.StatusCode, string(respBody))
+		return ingestResponse, err
+	}
+	ingestResponse.RequestID, _ = uuid.Parse(resp.Header.Get("x-request-id"))
+
+	return ingestResponse, nil
+}
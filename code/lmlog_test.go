@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLMLogMarshalJSON(t *testing.T) {
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:    "delete pods/web-0",
+			Timestamp:  time.Date(2022, time.August, 25, 14, 6, 8, 0, time.UTC),
+			ResourceID: map[string]string{"system.aws.arn": "arn:aws:eks:us-west-1:280443500820:cluster/ak-eks-logs"},
+		},
+		Metadata: map[string]string{"eks.logType": "audit", "verb": "delete"},
+	}
+
+	body, err := json.Marshal([]LMLog{lmEv})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{
+		"msg": "delete pods/web-0",
+		"timestamp": "2022-08-25T14:06:08Z",
+		"_lm.resourceId": {"system.aws.arn": "arn:aws:eks:us-west-1:280443500820:cluster/ak-eks-logs"},
+		"eks.logType": "audit",
+		"verb": "delete"
+	}]`, string(body))
+}
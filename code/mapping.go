@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ec2InstanceID matches an EC2 instance ID, with 8 or 17 hex digits.
+const ec2InstanceID = `i-(?:[0-9a-f]{8}|[0-9a-f]{17})`
+
+// ec2InstanceIDPattern captures an EC2 instance ID, on its own or within a
+// longer name such as a log stream named after the host and instance.
+const ec2InstanceIDPattern = `(?:^|[^0-9A-Za-z])(?P<instance>` + ec2InstanceID + `)(?:$|[^0-9A-Za-z])`
+
+// ec2InstanceIDRegex matches a value that is an EC2 instance ID.
+var ec2InstanceIDRegex = regexp.MustCompile(`^` + ec2InstanceID + `$`)
+
+var resourceMappingRules []*resourceMappingRule
+
+// resourceMappingRule maps CloudWatch log events to a LogicMonitor resource.
+// LogGroup, LogStream and Message are regular expressions that must all match
+// for the rule to apply, and a rule is skipped when LogGroupExclude matches.
+// Named captures of the patterns, as well as partition, region, account,
+// logGroup and logStream, can be referenced as ${name} in the ResourceID value
+// templates.
+type resourceMappingRule struct {
+	LogGroup        string            `json:"logGroup"`
+	LogGroupExclude string            `json:"logGroupExclude"`
+	LogStream       string            `json:"logStream"`
+	Message         string            `json:"message"`
+	ResourceID      map[string]string `json:"resourceId"`
+
+	logGroup        *regexp.Regexp
+	logGroupExclude *regexp.Regexp
+	logStream       *regexp.Regexp
+	message         *regexp.Regexp
+}
+
+// defaultResourceMappingRules are evaluated after the configured rules. Logs
+// of other log groups go to the EC2 instance found in the log stream name, or
+// to the account when there is none.
+var defaultResourceMappingRules = mustCompileResourceMappingRules([]*resourceMappingRule{
+	{
+		LogGroup:   `^RDSOSMetrics$`,
+		Message:    `"instanceID":\s*"(?P<instance>[^"]+)"`,
+		ResourceID: map[string]string{"system.aws.arn": "arn:${partition}:rds:${region}:${account}:db:${instance}"},
+	},
+	{
+		LogGroup:   `/aws/rds.*/networkInterface$`,
+		LogStream:  `^(?P<eni>[^-]*-[^-]*)`,
+		ResourceID: map[string]string{"system.aws.networkInterfaceId": "${eni}"},
+	},
+	{
+		LogGroup:   `/aws/rds/(instance|cluster)/(?P<db>[^/]*)`,
+		ResourceID: map[string]string{"system.aws.arn": "arn:${partition}:rds:${region}:${account}:db:${db}"},
+	},
+	{
+		LogGroup:        `/aws/lambda/(?P<function>.*)`,
+		LogGroupExclude: `^/aws/lambda/lm$`,
+		ResourceID:      map[string]string{"system.aws.arn": "arn:${partition}:lambda:${region}:${account}:function:${function}"},
+	},
+	{
+		LogGroup:   `/aws/ec2/networkInterface`,
+		Message:    "^" + ec2InstanceIDPattern,
+		ResourceID: map[string]string{"system.aws.arn": "arn:${partition}:ec2:${region}:${account}:instance/${instance}"},
+	},
+	{
+		LogGroup:   `/aws/natGateway/networkInterface`,
+		LogStream:  `^(?P<eni>[^-]*-[^-]*)`,
+		ResourceID: map[string]string{"system.aws.networkInterfaceId": "${eni}"},
+	},
+	{
+		LogGroup:   `/aws/kinesisfirehose/(?P<stream>[^/]*)`,
+		ResourceID: map[string]string{"system.aws.arn": "arn:${partition}:firehose:${region}:${account}:deliverystream/${stream}"},
+	},
+	{
+		LogGroup:   `/aws/elb/networkInterface`,
+		LogStream:  `^(?P<eni>[^-]*-[^-]*)`,
+		ResourceID: map[string]string{"system.aws.networkInterfaceId": "${eni}"},
+	},
+	{
+		LogGroup:   `/aws/fargate`,
+		ResourceID: accountResourceID("${account}"),
+	},
+	{
+		LogGroup:   `^API-Gateway-Execution-Logs_(?P<api>[a-z0-9]+)/(?P<stage>[^/]+)$`,
+		ResourceID: map[string]string{"system.aws.arn": "arn:${partition}:apigateway:${region}::/restapis/${api}/stages/${stage}"},
+	},
+	{
+		LogGroup:   `/aws/eks/(?P<cluster>.*)/cluster`,
+		ResourceID: map[string]string{"system.aws.arn": "arn:${partition}:eks:${region}:${account}:cluster/${cluster}"},
+	},
+	{
+		LogStream:  ec2InstanceIDPattern,
+		ResourceID: map[string]string{"system.aws.arn": "arn:${partition}:ec2:${region}:${account}:instance/${instance}"},
+	},
+	{
+		ResourceID: accountResourceID("${account}"),
+	},
+})
+
+func parseResourceMappingRules(config string) ([]*resourceMappingRule, error) {
+	rules := make([]*resourceMappingRule, 0)
+	if strings.TrimSpace(config) == "" {
+		return rules, nil
+	}
+
+	err := json.Unmarshal([]byte(config), &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	err = compileResourceMappingRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func compileResourceMappingRules(rules []*resourceMappingRule) error {
+	compile := func(pattern string) (*regexp.Regexp, error) {
+		if pattern == "" {
+			return nil, nil
+		}
+		return regexp.Compile(pattern)
+	}
+
+	for i, rule := range rules {
+		if len(rule.ResourceID) == 0 {
+			return fmt.Errorf("rule %d: resourceId is required", i)
+		}
+
+		var err error
+		if rule.logGroup, err = compile(rule.LogGroup); err != nil {
+			return fmt.Errorf("rule %d: %s", i, err)
+		}
+		if rule.logGroupExclude, err = compile(rule.LogGroupExclude); err != nil {
+			return fmt.Errorf("rule %d: %s", i, err)
+		}
+		if rule.logStream, err = compile(rule.LogStream); err != nil {
+			return fmt.Errorf("rule %d: %s", i, err)
+		}
+		if rule.message, err = compile(rule.Message); err != nil {
+			return fmt.Errorf("rule %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+func mustCompileResourceMappingRules(rules []*resourceMappingRule) []*resourceMappingRule {
+	err := compileResourceMappingRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// match reports whether the rule applies to a log event, returning the
+// template variables captured from it.
+func (rule *resourceMappingRule) match(data events.CloudwatchLogsData, region string, message string) (map[string]string, bool) {
+	if rule.logGroupExclude != nil && rule.logGroupExclude.MatchString(data.LogGroup) {
+		return nil, false
+	}
+
+	region = resolveRegion(region)
+	vars := map[string]string{
+		"partition": partitionForRegion(region),
+		"region":    region,
+		"account":   resolveAccountID(data.Owner),
+		"logGroup":  data.LogGroup,
+		"logStream": data.LogStream,
+	}
+
+	capture := func(re *regexp.Regexp, value string) bool {
+		if re == nil {
+			return true
+		}
+		result := re.FindStringSubmatch(value)
+		if result == nil {
+			return false
+		}
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				vars[name] = result[i]
+			}
+		}
+		return true
+	}
+
+	if !capture(rule.logGroup, data.LogGroup) || !capture(rule.logStream, data.LogStream) || !capture(rule.message, message) {
+		return nil, false
+	}
+	return vars, true
+}
+
+func (rule *resourceMappingRule) expand(vars map[string]string) map[string]string {
+	resourceID := make(map[string]string)
+	for property, template := range rule.ResourceID {
+		resourceID[property] = os.Expand(template, func(name string) string {
+			return vars[name]
+		})
+	}
+	return resourceID
+}
+
+// resolveResourceID returns the resource properties of the first configured
+// or default rule matching the log event. Events whose rule yields an invalid
+// ARN are mapped to the account instead. The region of the templates is the
+// given one, such as that of the stream the event was read from, or else the
+// forwarder's.
+func resolveResourceID(data events.CloudwatchLogsData, region string, message string) map[string]string {
+	for _, rules := range [][]*resourceMappingRule{resourceMappingRules, defaultResourceMappingRules} {
+		if resourceID, ok := matchResourceMappingRules(rules, data, region, message); ok {
+			return resourceID
+		}
+	}
+	return nil
+}
+
+// matchResourceMappingRules returns the resource properties of the first of
+// the rules matching the log event, and whether one matched.
+func matchResourceMappingRules(rules []*resourceMappingRule, data events.CloudwatchLogsData, region string, message string) (map[string]string, bool) {
+	for _, rule := range rules {
+		vars, ok := rule.match(data, region, message)
+		if !ok {
+			continue
+		}
+
+		resourceID := rule.expand(vars)
+		if arn, ok := resourceID["system.aws.arn"]; ok {
+			if err := validateARN(arn); err != nil {
+				fmt.Printf("WARN log group %s: %s\n", data.LogGroup, err)
+				return accountResourceID(resolveAccountID(data.Owner)), true
+			}
+		}
+		return resourceID, true
+	}
+	return nil, false
+}
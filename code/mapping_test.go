@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResourceMappingRules(t *testing.T) {
+
+	t.Run("empty config", func(t *testing.T) {
+		rules, err := parseResourceMappingRules(" ")
+		assert.NoError(t, err)
+		assert.Empty(t, rules)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := parseResourceMappingRules(`[{"logGroup":"(","resourceId":{"system.aws.arn":"x"}}]`)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing resource", func(t *testing.T) {
+		_, err := parseResourceMappingRules(`[{"logGroup":"^/app/"}]`)
+		assert.Error(t, err)
+	})
+}
+
+func TestResolveResourceID(t *testing.T) {
+	data := events.CloudwatchLogsData{
+		Owner:     "123123123123",
+		LogGroup:  "/app/orders/production",
+		LogStream: "orders/web/0f1e2d3c",
+	}
+
+	t.Run("default rules", func(t *testing.T) {
+		resourceID := resolveResourceID(data, "", "started")
+		assert.Equal(t, accountResourceID("123123123123"), resourceID)
+	})
+
+	t.Run("ec2 instance log stream", func(t *testing.T) {
+		streams := map[string]string{
+			"i-01fb3c5139e4b27bb":                "i-01fb3c5139e4b27bb",
+			"i-0a1b2c3d":                         "i-0a1b2c3d",
+			"web-1/i-01fb3c5139e4b27bb/messages": "i-01fb3c5139e4b27bb",
+			"ip-10-0-0-1_i-01fb3c5139e4b27bb":    "i-01fb3c5139e4b27bb",
+		}
+		for logStream, instanceID := range streams {
+			ec2Data := data
+			ec2Data.LogStream = logStream
+			assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2::123123123123:instance/" + instanceID}, resolveResourceID(ec2Data, "", "started"), logStream)
+		}
+
+		for _, logStream := range []string{"app/web-1/abcdef", "i-0a1b2c", "i-0a1b2c3d4e", "i-01fb3c5139e4b27bb0a1", "xi-01fb3c5139e4b27bb", "i-01FB3C51"} {
+			ec2Data := data
+			ec2Data.LogStream = logStream
+			assert.Equal(t, accountResourceID("123123123123"), resolveResourceID(ec2Data, "", "started"), logStream)
+		}
+	})
+
+	t.Run("configured rules take precedence", func(t *testing.T) {
+		rules, err := parseResourceMappingRules(`[
+			{"logGroup": "^/app/(?P<service>[^/]+)/(?P<env>[^/]+)$", "logStream": "^[^/]+/(?P<task>[^/]+)/",
+			 "resourceId": {"system.aws.arn": "arn:aws:ecs:${region}:${account}:service/${env}/${service}-${task}"}},
+			{"logGroup": "^/aws/lambda/", "resourceId": {"system.hostname": "${logStream}"}}
+		]`)
+		assert.NoError(t, err)
+		resourceMappingRules = rules
+		defer func() { resourceMappingRules = nil }()
+
+		resourceID := resolveResourceID(data, "", "started")
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ecs::123123123123:service/production/orders-web"}, resourceID)
+
+		lambdaData := data
+		lambdaData.LogGroup = "/aws/lambda/orders"
+		resourceID = resolveResourceID(lambdaData, "", "started")
+		assert.Equal(t, map[string]string{"system.hostname": "orders/web/0f1e2d3c"}, resourceID)
+	})
+
+	t.Run("message captures", func(t *testing.T) {
+		rules, _ := parseResourceMappingRules(`[{"logGroup": "^/app/", "message": "host=(?P<host>\\S+)", "resourceId": {"system.hostname": "${host}"}}]`)
+		resourceMappingRules = rules
+		defer func() { resourceMappingRules = nil }()
+
+		assert.Equal(t, map[string]string{"system.hostname": "web-1"}, resolveResourceID(data, "", "level=info host=web-1"))
+		assert.Equal(t, accountResourceID("123123123123"), resolveResourceID(data, "", "level=info"))
+	})
+
+	t.Run("excluded log group", func(t *testing.T) {
+		lambdaData := data
+		lambdaData.LogGroup = "/aws/lambda/lm"
+		lambdaData.LogStream = "i-01fb3c5139e4b27bb"
+		resourceID := resolveResourceID(lambdaData, "", "started")
+		assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2::123123123123:instance/i-01fb3c5139e4b27bb"}, resourceID)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultMultilineMaxLines = 500
+	defaultMultilineMaxBytes = 65536
+)
+
+var multilineRules []*multilineRule
+
+// multilineRule describes how lines of a multi-line record, such as a stack
+// trace, are recognised and merged into the event that precedes them. A rule
+// applies to CloudWatch log groups starting with LogGroupPrefix or to S3 keys
+// starting with KeyPrefix.
+type multilineRule struct {
+	LogGroupPrefix      string `json:"logGroupPrefix"`
+	KeyPrefix           string `json:"keyPrefix"`
+	StartPattern        string `json:"startPattern"`
+	ContinuationPattern string `json:"continuationPattern"`
+	MaxLines            int    `json:"maxLines"`
+	MaxBytes            int    `json:"maxBytes"`
+
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+}
+
+func parseMultilineRules(config string) ([]*multilineRule, error) {
+	rules := make([]*multilineRule, 0)
+	if strings.TrimSpace(config) == "" {
+		return rules, nil
+	}
+
+	err := json.Unmarshal([]byte(config), &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range rules {
+		if rule.LogGroupPrefix == "" && rule.KeyPrefix == "" {
+			return nil, fmt.Errorf("rule %d: logGroupPrefix or keyPrefix is required", i)
+		}
+		if (rule.StartPattern == "") == (rule.ContinuationPattern == "") {
+			return nil, fmt.Errorf("rule %d: exactly one of startPattern or continuationPattern is required", i)
+		}
+
+		if rule.StartPattern != "" {
+			rule.start, err = regexp.Compile(rule.StartPattern)
+		} else {
+			rule.continuation, err = regexp.Compile(rule.ContinuationPattern)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i, err)
+		}
+
+		if rule.MaxLines <= 0 {
+			rule.MaxLines = defaultMultilineMaxLines
+		}
+		if rule.MaxBytes <= 0 {
+			rule.MaxBytes = defaultMultilineMaxBytes
+		}
+	}
+	return rules, nil
+}
+
+func multilineRuleForLogGroup(logGroup string) *multilineRule {
+	for _, rule := range multilineRules {
+		if rule.LogGroupPrefix != "" && strings.HasPrefix(logGroup, rule.LogGroupPrefix) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func multilineRuleForKey(key string) *multilineRule {
+	for _, rule := range multilineRules {
+		if rule.KeyPrefix != "" && strings.HasPrefix(key, rule.KeyPrefix) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (rule *multilineRule) isContinuation(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	if rule.start != nil {
+		return !rule.start.MatchString(line)
+	}
+	return rule.continuation.MatchString(line)
+}
+
+// aggregateMultiline merges continuation lines into the preceding event of
+// the same resource, keeping its timestamp and metadata. An event is closed
+// once it reaches the rule's line or byte limit.
+func aggregateMultiline(logs []LMLog, rule *multilineRule) []LMLog {
+	if rule == nil || len(logs) == 0 {
+		return logs
+	}
+
+	lmBatch := make([]LMLog, 0, len(logs))
+	lines := 0
+
+	for _, event := range logs {
+		if len(lmBatch) > 0 && lines < rule.MaxLines && rule.isContinuation(event.Message) &&
+			reflect.DeepEqual(lmBatch[len(lmBatch)-1].ResourceID, event.ResourceID) {
+			current := &lmBatch[len(lmBatch)-1]
+			merged := strings.TrimRight(current.Message, "\r\n") + "\n" + event.Message
+			if len(merged) <= rule.MaxBytes {
+				current.Message = merged
+				lines++
+				continue
+			}
+		}
+
+		lmBatch = append(lmBatch, event)
+		lines = 1
+	}
+	return lmBatch
+}
+
+// splitLines breaks file content into one event per non-empty line, all
+// sharing the given timestamp and resource.
+func splitLines(content string, template LMLog) []LMLog {
+	lmBatch := make([]LMLog, 0)
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lmEv := template
+		lmEv.Message = strings.TrimRight(line, "\r")
+		lmBatch = append(lmBatch, lmEv)
+	}
+	return lmBatch
+}
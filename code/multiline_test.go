@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMultilineRules(t *testing.T) {
+
+	t.Run("empty config", func(t *testing.T) {
+		rules, err := parseMultilineRules("")
+		assert.NoError(t, err)
+		assert.Empty(t, rules)
+	})
+
+	t.Run("defaults limits", func(t *testing.T) {
+		rules, err := parseMultilineRules(`[{"logGroupPrefix":"/aws/lambda/java","startPattern":"^\\d{4}-"}]`)
+		assert.NoError(t, err)
+		assert.Equal(t, defaultMultilineMaxLines, rules[0].MaxLines)
+		assert.Equal(t, defaultMultilineMaxBytes, rules[0].MaxBytes)
+	})
+
+	t.Run("requires a single pattern", func(t *testing.T) {
+		_, err := parseMultilineRules(`[{"logGroupPrefix":"/aws/lambda/java"}]`)
+		assert.Error(t, err)
+
+		_, err = parseMultilineRules(`[{"keyPrefix":"app/","startPattern":"^a","continuationPattern":"^b"}]`)
+		assert.Error(t, err)
+	})
+
+	t.Run("requires a prefix", func(t *testing.T) {
+		_, err := parseMultilineRules(`[{"startPattern":"^\\d{4}-"}]`)
+		assert.Error(t, err)
+	})
+}
+
+func TestAggregateMultiline(t *testing.T) {
+	timestamp := time.Unix(1586351314, 0)
+	resourceID := map[string]string{"system.aws.arn": "arn:aws:lambda:us-west-1:123123123123:function:app"}
+	logsFor := func(messages ...string) []LMLog {
+		logs := make([]LMLog, 0)
+		for i, message := range messages {
+			logs = append(logs, LMLog{
+				Log: ingest.Log{
+					Message:    message,
+					ResourceID: resourceID,
+					Timestamp:  timestamp.Add(time.Duration(i) * time.Millisecond),
+				},
+			})
+		}
+		return logs
+	}
+
+	t.Run("start pattern", func(t *testing.T) {
+		rules, _ := parseMultilineRules(`[{"logGroupPrefix":"/aws/lambda","startPattern":"^\\d{4}-\\d{2}-\\d{2}"}]`)
+		logs := logsFor(
+			"2021-04-26 11:15:15 ERROR request failed\n",
+			"java.lang.IllegalStateException: boom\n",
+			"\tat com.example.Handler.handle(Handler.java:42)\n",
+			"2021-04-26 11:15:16 INFO next request\n",
+		)
+
+		lmEvents := aggregateMultiline(logs, rules[0])
+
+		assert.Equal(t, 2, len(lmEvents))
+		assert.Equal(t, ingest.Log{
+			Message:    "2021-04-26 11:15:15 ERROR request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Handler.handle(Handler.java:42)\n",
+			ResourceID: resourceID,
+			Timestamp:  timestamp,
+		}, lmEvents[0].Log)
+		assert.Equal(t, logs[3], lmEvents[1])
+	})
+
+	t.Run("continuation pattern", func(t *testing.T) {
+		rules, _ := parseMultilineRules(`[{"keyPrefix":"app/","continuationPattern":"^(\\s+|Traceback|\\w+Error:)"}]`)
+		logs := logsFor(
+			"Traceback (most recent call last):",
+			"  File \"app.py\", line 3, in <module>",
+			"ValueError: bad value",
+			"request done",
+		)
+
+		lmEvents := aggregateMultiline(logs, rules[0])
+
+		assert.Equal(t, 2, len(lmEvents))
+		assert.Equal(t, "Traceback (most recent call last):\n  File \"app.py\", line 3, in <module>\nValueError: bad value", lmEvents[0].Message)
+		assert.Equal(t, "request done", lmEvents[1].Message)
+	})
+
+	t.Run("max lines", func(t *testing.T) {
+		rules, _ := parseMultilineRules(`[{"keyPrefix":"app/","continuationPattern":"^\\s","maxLines":2}]`)
+
+		lmEvents := aggregateMultiline(logsFor("error", " at a", " at b", " at c"), rules[0])
+
+		assert.Equal(t, []string{"error\n at a", " at b\n at c"}, []string{lmEvents[0].Message, lmEvents[1].Message})
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		rules, _ := parseMultilineRules(`[{"keyPrefix":"app/","continuationPattern":"^\\s","maxBytes":12}]`)
+
+		lmEvents := aggregateMultiline(logsFor("error", " at a", " at b"), rules[0])
+
+		assert.Equal(t, []string{"error\n at a", " at b"}, []string{lmEvents[0].Message, lmEvents[1].Message})
+	})
+
+	t.Run("other resource", func(t *testing.T) {
+		rules, _ := parseMultilineRules(`[{"keyPrefix":"app/","continuationPattern":"^\\s"}]`)
+		logs := logsFor("error", " at a", " at b")
+		logs[2].ResourceID = map[string]string{"system.aws.arn": "arn:aws:lambda:us-west-1:123456789012:function:other"}
+
+		lmEvents := aggregateMultiline(logs, rules[0])
+
+		assert.Equal(t, []string{"error\n at a", " at b"}, []string{lmEvents[0].Message, lmEvents[1].Message})
+		assert.Equal(t, logs[2].ResourceID, lmEvents[1].ResourceID)
+	})
+}
+
+func TestParseS3logsMultiline(t *testing.T) {
+	rules, _ := parseMultilineRules(`[{"keyPrefix":"app/","startPattern":"^\\d{4}-"}]`)
+	multilineRules = rules
+	defer func() { multilineRules = nil }()
+
+	time, _ := time.Parse(time.RFC3339, "2020-04-08T13:08:34+00:00")
+	s3Event := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "LogBucket"},
+				Object: events.S3Object{Key: "app/2020/04/08/app.log"},
+			},
+			EventTime: time,
+		}},
+	}
+
+	var getContentsFromS3BucketMock = func(bucket string, key string) string {
+		return "owner LogBucket [08/Apr/2020:13:08:34 +0000]\n2020-04-08 ERROR failed\n  at main.go:10\n2020-04-08 INFO ok\n"
+	}
+
+	lmEvents := parseS3logs(s3Event, getContentsFromS3BucketMock)
+
+	assert.Equal(t, 3, len(lmEvents))
+	assert.Equal(t, ingest.Log{
+		Message:    "2020-04-08 ERROR failed\n  at main.go:10",
+		Timestamp:  time,
+		ResourceID: map[string]string{"system.aws.arn": "arn:aws:s3:::LogBucket"},
+	}, lmEvents[1].Log)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	goparquet "github.com/fraugster/parquet-go"
+)
+
+// parquetMagic starts and ends every Apache Parquet file.
+const parquetMagic = "PAR1"
+
+// readParquetFlowLogs returns the field names of a flow log file in Apache
+// Parquet and its rows as text records, with missing values written as - like
+// in text flow logs. Parquet column names use underscores where the flow log
+// fields use hyphens.
+func readParquetFlowLogs(content string) ([]string, []string, error) {
+	reader, err := goparquet.NewFileReader(strings.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := reader.Columns()
+	fields := make([]string, 0, len(columns))
+	for _, column := range columns {
+		fields = append(fields, strings.ReplaceAll(column.Name(), "_", "-"))
+	}
+
+	lines := make([]string, 0, reader.NumRows())
+	for {
+		row, err := reader.NextRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		values := make([]string, 0, len(columns))
+		for _, column := range columns {
+			values = append(values, formatParquetValue(row[column.Name()]))
+		}
+		lines = append(lines, strings.Join(values, " "))
+	}
+	return fields, lines, nil
+}
+
+func formatParquetValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case []byte:
+		if len(v) == 0 {
+			return "-"
+		}
+		return string(v)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
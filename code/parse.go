@@ -2,7 +2,6 @@ package main
 
 import (
 	"compress/gzip"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -14,18 +13,14 @@ import (
 	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
 )
 
-var s3Regex, _ = regexp.Compile(`("ARN":")(?P<arn>[^/][^,][^"]*)`)
-
-func parseELBlogs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]ingest.Log, error) {
-	lmBatch := make([]ingest.Log, 0)
+func parseELBlogs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
 
 	bucketName := request.Records[0].S3.Bucket.Name
 	key := request.Records[0].S3.Object.Key
-	content := getContentsFromS3Bucket(bucketName, key)
-
-	filetype := http.DetectContentType([]byte(content))
-	if filetype == "application/x-gzip" {
-		content = decompressGzip(content)
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
 	}
 
 	keySplit := strings.Split(key, "_")
@@ -48,44 +43,62 @@ func parseELBlogs(request events.S3Event, getContentsFromS3Bucket GetContentFrom
 	elbName := strings.ReplaceAll(name, ".", "/")
 	allMessages := strings.Split(content, "\n")
 
-	arn := fmt.Sprintf("arn:aws:elasticloadbalancing:%s:%s:loadbalancer/%s", region, accountId, elbName)
+	arn, err := buildARN("elasticloadbalancing", region, accountId, "loadbalancer/"+elbName)
+	if err != nil {
+		return lmBatch, err
+	}
 
 	for _, message := range allMessages {
 
-		log := ingest.Log{
-			Message:    message,
-			ResourceID: map[string]string{"system.aws.arn": arn},
-			Timestamp:  request.Records[0].EventTime,
+		log := LMLog{
+			Log: ingest.Log{
+				Message:    message,
+				ResourceID: map[string]string{"system.aws.arn": arn},
+				Timestamp:  request.Records[0].EventTime,
+			},
 		}
 
 		lmBatch = append(lmBatch, log)
 	}
-	return lmBatch, nil
+	return aggregateMultiline(lmBatch, multilineRuleForKey(key)), nil
 }
 
-func parseS3logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) []ingest.Log {
+func parseS3logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) []LMLog {
 	var arn string
+	var err error
 	bucketName := request.Records[0].S3.Bucket.Name
 	fileName := request.Records[0].S3.Object.Key
+	region := resolveRegion(request.Records[0].AWSRegion)
 
 	content := getContentsFromS3Bucket(bucketName, fileName)
 
 	filetype := http.DetectContentType([]byte(content))
 
 	if filetype != "application/x-gzip" {
-		originBucketName := strings.Split(content, " ")[1]
-		arn = fmt.Sprintf("arn:aws:s3:::%s", originBucketName)
+		// S3 access logs name the bucket they are about in their second field.
+		originBucketName := bucketName
+		if fields := strings.Fields(content); len(fields) > 1 {
+			originBucketName = fields[1]
+		}
+		arn, err = buildGlobalARN("s3", region, "", originBucketName)
 	} else {
 		content = decompressGzip(content)
-		arn = fmt.Sprintf("arn:aws:s3:::%s", bucketName)
+		arn, err = buildGlobalARN("s3", region, "", bucketName)
 	}
+	handleFatalError("failed to build s3 bucket arn", err)
 
-	lmBatch := make([]ingest.Log, 0)
+	lmBatch := make([]LMLog, 0)
+
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:    content,
+			ResourceID: map[string]string{"system.aws.arn": arn},
+			Timestamp:  request.Records[0].EventTime,
+		},
+	}
 
-	lmEv := ingest.Log{
-		Message:    content,
-		ResourceID: map[string]string{"system.aws.arn": arn},
-		Timestamp:  request.Records[0].EventTime,
+	if rule := multilineRuleForKey(fileName); rule != nil {
+		return aggregateMultiline(splitLines(content, lmEv), rule)
 	}
 
 	lmBatch = append(lmBatch, lmEv)
@@ -93,168 +106,106 @@ func parseS3logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS
 	return lmBatch
 }
 
-func parseCloudWatchLogs(request events.CloudwatchLogsEvent) []ingest.Log {
-
-	lmBatch := make([]ingest.Log, 0)
+func parseCloudWatchLogs(request events.CloudwatchLogsEvent) []LMLog {
 	d, err := request.AWSLogs.Parse()
-	var resourceValue string
-	var resoureProp = make(map[string]string)
-	var isEC2NetworkInterface bool = false
-	var resourceProperty string = "system.aws.arn"
-
-	if d.LogGroup == "RDSOSMetrics" {
-		rdsEnhancedEvent := make(map[string]interface{})
-		err := json.Unmarshal([]byte(d.LogEvents[0].Message), &rdsEnhancedEvent)
-		handleFatalError("RDSOSMetrics event parsing failed", err)
-		rdsInstance := rdsEnhancedEvent["instanceID"]
-		resourceValue = fmt.Sprintf("arn:aws:rds:%s:%s:db:%s", awsRegion, d.Owner, rdsInstance)
-		resoureProp[resourceProperty] = resourceValue
-	} else if strings.Contains(d.LogGroup, "/aws/rds") {
-		splitLogGroup := strings.Split(d.LogGroup, "/")
-		if splitLogGroup[len(splitLogGroup)-1] == "networkInterface" {
-			resourceProperty = "system.aws.networkInterfaceId"
-			splitLogStream := strings.Split(d.LogStream, "-")
-			resourceValue = splitLogStream[0] + "-" + splitLogStream[1]
-			resoureProp[resourceProperty] = resourceValue
-		} else {
-			re1, _ := regexp.Compile(`/aws/rds/(instance|cluster)/([^/]*)`)
-			result := re1.FindStringSubmatch(d.LogGroup)
-			rdsInstance := result[2]
-			resourceValue = fmt.Sprintf("arn:aws:rds:%s:%s:db:%s", awsRegion, d.Owner, rdsInstance)
-			resoureProp[resourceProperty] = resourceValue
-		}
-	} else if d.LogGroup != "/aws/lambda/lm" && strings.Contains(d.LogGroup, "/aws/lambda") {
-		re1, _ := regexp.Compile(`aws/lambda/(.*)`)
-		result := re1.FindStringSubmatch(d.LogGroup)
-		lambdaName := result[1]
-		resourceValue = fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", awsRegion, d.Owner, lambdaName)
-		resoureProp[resourceProperty] = resourceValue
-	} else if strings.Contains(d.LogGroup, "/aws/ec2/networkInterface") {
-		isEC2NetworkInterface = true
-	} else if strings.Contains(d.LogGroup, "/aws/natGateway/networkInterface") {
-		resourceProperty = "system.aws.networkInterfaceId"
-		splitLogStream := strings.Split(d.LogStream, "-")
-		resourceValue = splitLogStream[0] + "-" + splitLogStream[1]
-		resoureProp[resourceProperty] = resourceValue
-	} else if strings.Contains(d.LogGroup, "/aws/kinesisfirehose") {
-		splitLogGroup := strings.Split(d.LogGroup, "/")
-		resourceValue = splitLogGroup[3]
-		resoureProp[resourceProperty] = fmt.Sprintf("arn:aws:firehose:%s:%s:deliverystream/%s", awsRegion, d.Owner, resourceValue)
-	} else if strings.Contains(d.LogGroup, "/aws/elb/networkInterface") {
-		resourceProperty = "system.aws.networkInterfaceId"
-		splitLogStream := strings.Split(d.LogStream, "-")
-		resourceValue = splitLogStream[0] + "-" + splitLogStream[1]
-		resoureProp[resourceProperty] = resourceValue
-	} else if strings.Contains(d.LogGroup, "/aws/fargate") {
-		resoureProp["system.aws.accountid"] = d.Owner
-		resoureProp["system.cloud.category"] = "AWS/LMAccount"
-	} else if strings.Contains(d.LogGroup, "/aws/cloudtrail") {
+	handleFatalError("failed to parse cloudwatch event", err)
+
+	return parseCloudWatchLogsData(d, "")
+}
+
+// parseCloudWatchLogsData maps the log events of a CloudWatch Logs
+// subscription payload, whether delivered to Lambda directly or through
+// Kinesis, to their resources. The region is that of the stream the payload
+// was read from, if any, and defaults to the forwarder's.
+func parseCloudWatchLogsData(d events.CloudwatchLogsData, region string) []LMLog {
+	lmBatch := make([]LMLog, 0)
+
+	if strings.Contains(d.LogGroup, "/aws/cloudtrail") {
 		return parseCloudTrailLogs(d)
-	} else if strings.Contains(d.LogGroup, "/aws/eks") {
-		re1, _ := regexp.Compile(`aws/eks/(.*)/cluster`)
-		result := re1.FindStringSubmatch(d.LogGroup)
-		eksName := result[1]
-		resourceValue = fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", awsRegion, d.Owner, eksName)
-		resoureProp[resourceProperty] = resourceValue
-	} else {
-		resourceValue = fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", awsRegion, d.Owner, d.LogStream)
-		resoureProp[resourceProperty] = resourceValue
 	}
 
-	handleFatalError("failed to parse cloudwatch event", err)
+	if isWAFLogGroup(d.LogGroup) {
+		return parseWAFCloudWatchLogs(d)
+	}
+
+	isEKSControlPlane := eksClusterLogGroupRegex.MatchString(d.LogGroup)
+	isFlowLog := isFlowLogGroup(d.LogGroup)
+	flowLogFields := flowLogFieldsForLogGroup(d.LogGroup)
+	isResolverQueryLog := isResolverQueryLogGroup(d.LogGroup)
+	isAPIGatewayExecutionLog := isAPIGatewayExecutionLogGroup(d.LogGroup)
+	isAPIGatewayAccessLog := isAPIGatewayAccessLogGroup(d.LogGroup)
 
 	for _, event := range d.LogEvents {
 		if strings.TrimSpace(event.Message) != "" {
-			if isEC2NetworkInterface && resourceValue == "" {
-				splitEventMessage := strings.Split(event.Message, " ")
-				ec2InstanceID := splitEventMessage[0]
-				resourceValue = fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", awsRegion, d.Owner, ec2InstanceID)
-				resoureProp[resourceProperty] = resourceValue
+			lmEv := LMLog{
+				Log: ingest.Log{
+					Message:    event.Message,
+					ResourceID: resolveResourceID(d, region, event.Message),
+					Timestamp:  time.Unix(0, event.Timestamp*1000000),
+				},
 			}
-
-			lmEv := ingest.Log{
-				Message:    event.Message,
-				ResourceID: resoureProp,
-				Timestamp:  time.Unix(0, event.Timestamp*1000000),
+			if isEKSControlPlane {
+				lmEv.Metadata = parseEKSControlPlaneLog(d.LogStream, event.Message)
+			} else if isFlowLog {
+				if record := parseFlowLogRecord(flowLogFields, event.Message); record != nil {
+					lmEv.ResourceID = flowLogResourceID(d, region, event.Message, record)
+					lmEv.Metadata = record
+				}
+			} else if isResolverQueryLog {
+				if record := parseResolverQueryLog(event.Message); record != nil {
+					lmEv.ResourceID = resolverQueryLogCloudWatchResourceID(d, region, event.Message, record)
+					lmEv.Metadata = resolverQueryLogAttributes(record)
+				}
+			} else if isAPIGatewayExecutionLog {
+				lmEv.Metadata = parseAPIGatewayExecutionLog(event.Message)
+			} else if isAPIGatewayAccessLog {
+				lmEv.Metadata = parseAPIGatewayAccessLog(event.Message)
 			}
 			lmBatch = append(lmBatch, lmEv)
 		}
 	}
 
-	return lmBatch
+	if isFlowLog && flowLogAggregation {
+		lmBatch = aggregateFlowLogs(lmBatch)
+	}
+
+	return aggregateMultiline(lmBatch, multilineRuleForLogGroup(d.LogGroup))
 }
 
-func decompressGzip(content string) string {
-	rdata := strings.NewReader(content)
-	ioReaderContent, err := gzip.NewReader(rdata)
+// readS3Content returns the content of an S3 object, decompressed when it is
+// gzipped.
+func readS3Content(getContentsFromS3Bucket GetContentFromS3Bucket, bucketName string, key string) (string, error) {
+	content := getContentsFromS3Bucket(bucketName, key)
+	if http.DetectContentType([]byte(content)) != "application/x-gzip" {
+		return content, nil
+	}
 
+	content, err := gunzip(content)
 	if err != nil {
-		handleFatalError("error while parsing gzip file", err)
+		return "", fmt.Errorf("failed to decompress %s: %s", key, err)
 	}
-
-	defer ioReaderContent.Close()
-
-	strContent, _ := ioutil.ReadAll(ioReaderContent)
-	return string(strContent)
+	return content, nil
 }
 
-func parseCloudTrailLogs(data events.CloudwatchLogsData) []ingest.Log {
-	lmBatch := make([]ingest.Log, 0)
-
-	for _, event := range data.LogEvents {
-		var resoureIDMap = make(map[string]string)
-		eventSourceRegex, _ := regexp.Compile(`("eventSource":")([^",]*)`)
-		eventSourceArray := eventSourceRegex.FindStringSubmatch(event.Message)
-		eventSource := eventSourceArray[2]
-
-		accountLevelLog := true
-
-		if eventSource == "firehose.amazonaws.com" {
-			kinesisFirehoseRegex, _ := regexp.Compile(`("deliveryStreamName":"|"deliveryStreamName": "|:deliverystream/)([^/][^,][^"]*)`)
-			deliveryStreamArray := kinesisFirehoseRegex.FindStringSubmatch(event.Message)
-			if len(deliveryStreamArray) > 2 {
-				resoureIDMap["system.aws.arn"] = fmt.Sprintf("arn:aws:firehose:%s:%s:deliverystream/%s", awsRegion, data.Owner, deliveryStreamArray[2])
-				accountLevelLog = false
-			}
-		} else if eventSource == "kinesis.amazonaws.com" {
-			kinesisDataStreamRegex, _ := regexp.Compile(`("streamName":"|"streamName": "|:stream/)([^/][^,][^"]*)`)
-			dataStreamArray := kinesisDataStreamRegex.FindStringSubmatch(event.Message)
-			if len(dataStreamArray) > 2 {
-				resoureIDMap["system.aws.arn"] = fmt.Sprintf("arn:aws:kinesis:%s:%s:stream/%s", awsRegion, data.Owner, dataStreamArray[2])
-				accountLevelLog = false
-			}
-		} else if eventSource == "ecs.amazonaws.com" {
-			ecsStreamRegex, _ := regexp.Compile(`("cluster":"|"cluster": "|:cluster/)([^/][^,][^"]*)`)
-
-			ecsStreamArray := ecsStreamRegex.FindStringSubmatch(event.Message)
-
-			if len(ecsStreamArray) > 2 {
-				resoureIDMap["system.aws.arn"] = fmt.Sprintf("arn:aws:ecs:%s:%s:cluster/%s", awsRegion, data.Owner, ecsStreamArray[2])
-				accountLevelLog = false
-			}
-		} else if eventSource == "s3.amazonaws.com" {
-			s3RegexArray := s3Regex.FindStringSubmatch(event.Message)
-			s3Arn := s3Regex.SubexpIndex("arn")
-
-			if len(s3RegexArray) > 0 && s3Arn != 0 {
-				resoureIDMap["system.aws.arn"] = fmt.Sprintf(s3RegexArray[s3Arn])
-				accountLevelLog = false
-			}
-		}
-
-		if accountLevelLog {
-			resoureIDMap["system.aws.accountid"] = data.Owner
-			resoureIDMap["system.cloud.category"] = "AWS/LMAccount"
-		}
+func decompressGzip(content string) string {
+	decompressed, err := gunzip(content)
+	handleFatalError("error while parsing gzip file", err)
+	return decompressed
+}
 
-		lmEv := ingest.Log{
-			Message:    event.Message,
-			ResourceID: resoureIDMap,
-			Timestamp:  time.Unix(0, event.Timestamp*1000000),
-		}
-		lmBatch = append(lmBatch, lmEv)
+// gunzip decompresses gzipped content, returning an error rather than exiting
+// when it is corrupt or truncated. Trailing data after the last member, such
+// as padding, is ignored.
+func gunzip(content string) (string, error) {
+	reader, err := gzip.NewReader(strings.NewReader(content))
+	if err != nil {
+		return "", err
 	}
+	defer reader.Close()
 
-	return lmBatch
-
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil && err != gzip.ErrHeader {
+		return "", err
+	}
+	return string(decompressed), nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// resolverQueryLogS3KeyRegex matches the keys of Route 53 Resolver query log
+// files delivered to S3.
+var resolverQueryLogS3KeyRegex = regexp.MustCompile(`AWSLogs/(?P<account>\d{12})/vpcdnsquerylogs/(?P<vpc>vpc-[0-9a-f]+)/`)
+
+// resolverQueryLogConfigRegex finds the query logging configuration in the
+// log stream names of query logs delivered to CloudWatch Logs.
+var resolverQueryLogConfigRegex = regexp.MustCompile(`rqlc-[0-9a-f]+`)
+
+// resolverQueryLogGroups are the names or prefixes of the CloudWatch log
+// groups Route 53 Resolver query logging configurations deliver to.
+var resolverQueryLogGroups []string
+
+// isResolverQueryLogGroup reports whether a CloudWatch log group receives
+// Route 53 Resolver query logs.
+func isResolverQueryLogGroup(logGroup string) bool {
+	return hasLogGroupPrefix(logGroup, resolverQueryLogGroups)
+}
+
+// resolverQueryLog holds the fields of a Route 53 Resolver query log promoted
+// to attributes or used to find its VPC.
+type resolverQueryLog struct {
+	AccountID      string `json:"account_id"`
+	Region         string `json:"region"`
+	VPCID          string `json:"vpc_id"`
+	QueryTimestamp string `json:"query_timestamp"`
+	QueryName      string `json:"query_name"`
+	QueryType      string `json:"query_type"`
+	RCode          string `json:"rcode"`
+	SrcIDs         struct {
+		Instance string `json:"instance"`
+	} `json:"srcids"`
+}
+
+// parseResolverQueryLog decodes a Route 53 Resolver query log. It returns nil
+// for messages that are not query logs.
+func parseResolverQueryLog(message string) *resolverQueryLog {
+	if !strings.Contains(message, `"query_name"`) {
+		return nil
+	}
+
+	var record resolverQueryLog
+	err := json.Unmarshal([]byte(message), &record)
+	if err != nil || record.QueryName == "" {
+		return nil
+	}
+	return &record
+}
+
+func resolverQueryLogAttributes(record *resolverQueryLog) map[string]string {
+	fields := map[string]string{
+		"query_name":      record.QueryName,
+		"query_type":      record.QueryType,
+		"rcode":           record.RCode,
+		"srcids.instance": record.SrcIDs.Instance,
+	}
+	return attributes(fields)
+}
+
+// resolverQueryLogResourceID maps a query log to the VPC the query came from,
+// or else to the query logging configuration. It returns nil when the log
+// names neither.
+func resolverQueryLogResourceID(record *resolverQueryLog, configID string, owner string) map[string]string {
+	region := resolveRegion(record.Region)
+	accountID := resolveAccountID(record.AccountID, owner)
+
+	if record.VPCID != "" {
+		if arn, err := buildARN("ec2", region, accountID, "vpc/"+record.VPCID); err == nil {
+			return map[string]string{"system.aws.arn": arn}
+		}
+	}
+	if configID != "" {
+		if arn, err := buildARN("route53resolver", region, accountID, "resolver-query-log-config/"+configID); err == nil {
+			return map[string]string{"system.aws.arn": arn}
+		}
+	}
+	return nil
+}
+
+// resolverQueryLogCloudWatchResourceID returns the resource of a query log
+// delivered to CloudWatch Logs. Configured resource mapping rules take
+// precedence over the VPC or configuration of the query.
+func resolverQueryLogCloudWatchResourceID(data events.CloudwatchLogsData, region string, message string, record *resolverQueryLog) map[string]string {
+	if resourceID, ok := matchResourceMappingRules(resourceMappingRules, data, region, message); ok {
+		return resourceID
+	}
+	configID := resolverQueryLogConfigRegex.FindString(data.LogStream)
+	if resourceID := resolverQueryLogResourceID(record, configID, data.Owner); resourceID != nil {
+		return resourceID
+	}
+	return resolveResourceID(data, region, message)
+}
+
+// parseResolverQueryLogS3Logs splits a Route 53 Resolver query log file
+// delivered to S3 into one event per query.
+func parseResolverQueryLogS3Logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	bucketName := request.Records[0].S3.Bucket.Name
+	key := request.Records[0].S3.Object.Key
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
+	}
+
+	var owner string
+	if keyMatches := resolverQueryLogS3KeyRegex.FindStringSubmatch(key); keyMatches != nil {
+		owner = keyMatches[resolverQueryLogS3KeyRegex.SubexpIndex("account")]
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		lmEv := LMLog{
+			Log: ingest.Log{
+				Message:    line,
+				ResourceID: accountResourceID(resolveAccountID(owner)),
+				Timestamp:  request.Records[0].EventTime,
+			},
+		}
+		if record := parseResolverQueryLog(line); record != nil {
+			if resourceID := resolverQueryLogResourceID(record, "", owner); resourceID != nil {
+				lmEv.ResourceID = resourceID
+			}
+			if queryTime, err := time.Parse(time.RFC3339, record.QueryTimestamp); err == nil {
+				lmEv.Timestamp = queryTime
+			}
+			lmEv.Metadata = resolverQueryLogAttributes(record)
+		}
+		lmBatch = append(lmBatch, lmEv)
+	}
+	return lmBatch, nil
+}
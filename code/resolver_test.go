@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+const resolverInstanceQueryLog = `{"version":"1.100000","account_id":"197152445587","region":"ap-northeast-1","vpc_id":"vpc-0a1b2c3d4e5f67890","query_timestamp":"2021-04-26T05:51:34Z","query_name":"shop.example.com.","query_type":"A","query_class":"IN","rcode":"NOERROR","answers":[{"Rdata":"203.0.113.10","Type":"A","Class":"IN"}],"srcaddr":"10.0.1.25","srcport":"53152","transport":"UDP","srcids":{"instance":"i-0123456789abcdef0"}}`
+
+const resolverEndpointQueryLog = `{"version":"1.100000","account_id":"197152445587","region":"ap-northeast-1","query_timestamp":"2021-04-26T05:51:35Z","query_name":"db.corp.internal.","query_type":"AAAA","query_class":"IN","rcode":"NXDOMAIN","answers":[],"srcaddr":"192.168.10.4","srcport":"41234","transport":"UDP","srcids":{"resolver_endpoint":"rslvr-in-0a1b2c3d4e5f67890"}}`
+
+func TestParseResolverQueryLog(t *testing.T) {
+	record := parseResolverQueryLog(resolverInstanceQueryLog)
+
+	assert.NotNil(t, record)
+	assert.Equal(t, map[string]string{
+		"query_name":      "shop.example.com.",
+		"query_type":      "A",
+		"rcode":           "NOERROR",
+		"srcids.instance": "i-0123456789abcdef0",
+	}, resolverQueryLogAttributes(record))
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2:ap-northeast-1:197152445587:vpc/vpc-0a1b2c3d4e5f67890"}, resolverQueryLogResourceID(record, "", ""))
+
+	endpointRecord := parseResolverQueryLog(resolverEndpointQueryLog)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:route53resolver:ap-northeast-1:197152445587:resolver-query-log-config/rqlc-0a1b2c3d4e5f6789"}, resolverQueryLogResourceID(endpointRecord, "rqlc-0a1b2c3d4e5f6789", ""))
+	assert.Nil(t, resolverQueryLogResourceID(endpointRecord, "", ""))
+
+	assert.Nil(t, parseResolverQueryLog(`{"level":"info","msg":"started"}`))
+	assert.Nil(t, parseResolverQueryLog(`query_name lookup failed`))
+}
+
+func TestParseResolverQueryLogCloudWatchLogs(t *testing.T) {
+	resolverQueryLogGroups = parseCommaSeparated("dns-queries, /resolver/")
+	defer func() { resolverQueryLogGroups = nil }()
+
+	lmEvents := parseCloudWatchLogs(cloudWatchEventFor(events.CloudwatchLogsData{
+		Owner:     "197152445587",
+		LogGroup:  "dns-queries",
+		LogStream: "vpc-0a1b2c3d4e5f67890_rqlc-0a1b2c3d4e5f6789",
+		LogEvents: []events.CloudwatchLogsLogEvent{
+			{ID: "1", Timestamp: 1619416294250, Message: resolverInstanceQueryLog},
+			{ID: "2", Timestamp: 1619416295000, Message: resolverEndpointQueryLog},
+		},
+	}))
+
+	assert.Equal(t, 2, len(lmEvents))
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2:ap-northeast-1:197152445587:vpc/vpc-0a1b2c3d4e5f67890"}, lmEvents[0].ResourceID)
+	assert.Equal(t, "i-0123456789abcdef0", lmEvents[0].Metadata["srcids.instance"])
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:route53resolver:ap-northeast-1:197152445587:resolver-query-log-config/rqlc-0a1b2c3d4e5f6789"}, lmEvents[1].ResourceID)
+	assert.Equal(t, "NXDOMAIN", lmEvents[1].Metadata["rcode"])
+}
+
+func TestParseResolverQueryLogOtherLogGroups(t *testing.T) {
+	resolverQueryLogGroups = parseCommaSeparated("/resolver/")
+	defer func() { resolverQueryLogGroups = nil }()
+
+	lmEvents := parseCloudWatchLogs(cloudWatchEventFor(events.CloudwatchLogsData{
+		Owner:     "197152445587",
+		LogGroup:  "dns-queries",
+		LogStream: "vpc-0a1b2c3d4e5f67890_rqlc-0a1b2c3d4e5f6789",
+		LogEvents: []events.CloudwatchLogsLogEvent{
+			{ID: "1", Timestamp: 1619416294250, Message: resolverInstanceQueryLog},
+		},
+	}))
+
+	assert.Equal(t, 1, len(lmEvents))
+	assert.Equal(t, accountResourceID("197152445587"), lmEvents[0].ResourceID)
+	assert.Nil(t, lmEvents[0].Metadata)
+}
+
+func TestParseResolverQueryLogS3Logs(t *testing.T) {
+	key := "AWSLogs/197152445587/vpcdnsquerylogs/vpc-0a1b2c3d4e5f67890/2021/04/26/vpc-0a1b2c3d4e5f67890_vpcdnsquerylogs_197152445587_20210426T0550Z_a1b2c3d4.log.gz"
+	var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+		return gzipString(resolverInstanceQueryLog + "\n" + resolverEndpointQueryLog + "\n")
+	}
+
+	assert.Equal(t, "resolverquerylogs", s3EventType(s3EventFor("dns-logs", key)))
+
+	lmEvents, err := parseResolverQueryLogS3Logs(s3EventFor("dns-logs", key), getContentsFromS3BucketMock)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lmEvents))
+	assert.Equal(t, resolverInstanceQueryLog, lmEvents[0].Message)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:ec2:ap-northeast-1:197152445587:vpc/vpc-0a1b2c3d4e5f67890"}, lmEvents[0].ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 51, 34, 0, time.UTC), lmEvents[0].Timestamp.UTC())
+	assert.Equal(t, accountResourceID("197152445587"), lmEvents[1].ResourceID)
+	assert.Equal(t, "AAAA", lmEvents[1].Metadata["query_type"])
+}
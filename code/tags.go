@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultResourceTagsCacheTTL = 5 * time.Minute
+	resourceTagsFailureCacheTTL = 30 * time.Second
+	resourceTagsBatchSize       = 100
+	resourceTagsCacheSize       = 10000
+)
+
+var resourceTagKeys []string
+var resourceTagsCacheTTL = defaultResourceTagsCacheTTL
+
+// globalResourceTagsRegions are the regions, by partition, in which the
+// Resource Groups Tagging API lists the resources of global services.
+var globalResourceTagsRegions = map[string]string{
+	"aws":        "us-east-1",
+	"aws-cn":     "cn-northwest-1",
+	"aws-us-gov": "us-gov-west-1",
+}
+
+// globalResourceTagsServices are the services whose resources have ARNs
+// without a region and are listed in the global region of their partition.
+var globalResourceTagsServices = map[string]bool{
+	"cloudfront": true,
+	"iam":        true,
+	"route53":    true,
+}
+
+// resourceTagsCache keeps the tags of resources across warm invocations, for at
+// most resourceTagsCacheSize resources. Resources without tags are cached too
+// so they are not queried again until their entry expires. Resources whose
+// tags could not be read are cached for resourceTagsFailureCacheTTL only. The
+// regions of S3 buckets are kept as well, since bucket ARNs do not name them.
+var resourceTagsCache = struct {
+	sync.Mutex
+	entries       map[string]resourceTagsCacheEntry
+	bucketRegions map[string]string
+}{
+	entries:       make(map[string]resourceTagsCacheEntry),
+	bucketRegions: make(map[string]string),
+}
+
+type resourceTagsCacheEntry struct {
+	tags    map[string]string
+	expires time.Time
+}
+
+// GetResourceTags returns the tags of the given resources of a region, keyed
+// by ARN. Resources without tags may be missing from the result.
+type GetResourceTags func(region string, arns []string) (map[string]map[string]string, error)
+
+// GetBucketRegion returns the region an S3 bucket is located in.
+type GetBucketRegion func(bucket string) (string, error)
+
+// enrichWithResourceTags attaches the configured tags of each event's resource
+// as aws.tag.<key> attributes. A key of * attaches every tag.
+func enrichWithResourceTags(logs []LMLog, getResourceTags GetResourceTags, getBucketRegion GetBucketRegion) {
+	if len(resourceTagKeys) == 0 {
+		return
+	}
+
+	tagsByARN := lookupResourceTags(logs, getResourceTags, getBucketRegion)
+
+	for i := range logs {
+		tags := tagsByARN[logs[i].ResourceID["system.aws.arn"]]
+		for _, key := range resourceTagKeys {
+			for tagKey, tagValue := range tags {
+				if key != "*" && key != tagKey {
+					continue
+				}
+				if logs[i].Metadata == nil {
+					logs[i].Metadata = make(map[string]string)
+				}
+				logs[i].Metadata["aws.tag."+tagKey] = tagValue
+			}
+		}
+	}
+}
+
+// lookupResourceTags returns the tags of the resources of the given events,
+// querying only those missing from the cache or expired.
+func lookupResourceTags(logs []LMLog, getResourceTags GetResourceTags, getBucketRegion GetBucketRegion) map[string]map[string]string {
+	resourceTagsCache.Lock()
+	defer resourceTagsCache.Unlock()
+
+	now := time.Now()
+	evictExpiredResourceTags(now)
+	tagsByARN := make(map[string]map[string]string)
+	missingByRegion := make(map[string][]string)
+
+	for _, event := range logs {
+		arn := event.ResourceID["system.aws.arn"]
+		if _, ok := tagsByARN[arn]; ok || arn == "" {
+			continue
+		}
+
+		entry, ok := resourceTagsCache.entries[arn]
+		if ok && now.Before(entry.expires) {
+			tagsByARN[arn] = entry.tags
+			continue
+		}
+
+		tagsByARN[arn] = nil
+		region := resourceTagsRegion(arn, getBucketRegion)
+		missingByRegion[region] = append(missingByRegion[region], arn)
+	}
+
+	for region, arns := range missingByRegion {
+		for start := 0; start < len(arns); start += resourceTagsBatchSize {
+			end := start + resourceTagsBatchSize
+			if end > len(arns) {
+				end = len(arns)
+			}
+
+			result, err := getResourceTags(region, arns[start:end])
+			ttl := resourceTagsCacheTTL
+			if err != nil {
+				fmt.Printf("WARN failed to get resource tags in %s: %s\n", region, err)
+				if ttl > resourceTagsFailureCacheTTL {
+					ttl = resourceTagsFailureCacheTTL
+				}
+			}
+
+			for _, arn := range arns[start:end] {
+				tagsByARN[arn] = result[arn]
+				cacheResourceTags(arn, result[arn], now.Add(ttl))
+			}
+		}
+	}
+	return tagsByARN
+}
+
+// resourceTagsRegion returns the region in which the tags of a resource are
+// looked up: the region of its ARN, the global region of its partition for
+// global services, or the region of the bucket for S3 ARNs. It falls back to
+// the forwarder's region. The cache must be locked.
+func resourceTagsRegion(arn string, getBucketRegion GetBucketRegion) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return resolveRegion()
+	}
+	partition, service, region, resource := parts[1], parts[2], parts[3], parts[5]
+	if region != "" {
+		return region
+	}
+
+	if globalResourceTagsServices[service] {
+		if globalRegion, ok := globalResourceTagsRegions[partition]; ok {
+			return globalRegion
+		}
+	}
+
+	if service == "s3" {
+		bucket := strings.SplitN(resource, "/", 2)[0]
+		if bucketRegion, ok := resourceTagsCache.bucketRegions[bucket]; ok {
+			return bucketRegion
+		}
+		bucketRegion, err := getBucketRegion(bucket)
+		if err != nil {
+			fmt.Printf("WARN failed to get region of bucket %s: %s\n", bucket, err)
+			return resolveRegion()
+		}
+		if len(resourceTagsCache.bucketRegions) >= resourceTagsCacheSize {
+			resourceTagsCache.bucketRegions = make(map[string]string)
+		}
+		resourceTagsCache.bucketRegions[bucket] = bucketRegion
+		return bucketRegion
+	}
+	return resolveRegion()
+}
+
+// evictExpiredResourceTags removes the expired entries of the cache. The cache
+// must be locked.
+func evictExpiredResourceTags(now time.Time) {
+	for arn, entry := range resourceTagsCache.entries {
+		if !now.Before(entry.expires) {
+			delete(resourceTagsCache.entries, arn)
+		}
+	}
+}
+
+// cacheResourceTags stores the tags of a resource, evicting an arbitrary entry
+// when the cache is full. The cache must be locked.
+func cacheResourceTags(arn string, tags map[string]string, expires time.Time) {
+	if _, ok := resourceTagsCache.entries[arn]; !ok && len(resourceTagsCache.entries) >= resourceTagsCacheSize {
+		for evicted := range resourceTagsCache.entries {
+			delete(resourceTagsCache.entries, evicted)
+			break
+		}
+	}
+	resourceTagsCache.entries[arn] = resourceTagsCacheEntry{tags: tags, expires: expires}
+}
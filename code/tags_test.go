@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrichWithResourceTags(t *testing.T) {
+	resourceTagKeys = parseCommaSeparated("team, env")
+	defer func() {
+		resourceTagKeys = nil
+		resourceTagsCacheTTL = defaultResourceTagsCacheTTL
+		resourceTagsCache.entries = make(map[string]resourceTagsCacheEntry)
+		resourceTagsCache.bucketRegions = make(map[string]string)
+	}()
+
+	lambdaARN := "arn:aws:lambda:us-west-1:197152445587:function:observatory-worker"
+	bucketARN := "arn:aws:s3:::LogBucket"
+	distributionARN := "arn:aws:cloudfront::197152445587:distribution/E2QWRUHAPOMQZL"
+	logsFor := func() []LMLog {
+		return []LMLog{
+			{Log: ingest.Log{Message: "START", ResourceID: map[string]string{"system.aws.arn": lambdaARN}}},
+			{Log: ingest.Log{Message: "END", ResourceID: map[string]string{"system.aws.arn": lambdaARN}}},
+			{Log: ingest.Log{Message: "GET /", ResourceID: map[string]string{"system.aws.arn": bucketARN}}},
+			{Log: ingest.Log{Message: "GET /index.html", ResourceID: map[string]string{"system.aws.arn": distributionARN}}},
+			{Log: ingest.Log{Message: "trail", ResourceID: accountResourceID("197152445587")}},
+		}
+	}
+
+	bucketRegionCalls := 0
+	var getBucketRegionMock = func(bucket string) (string, error) {
+		bucketRegionCalls++
+		return "ap-northeast-1", nil
+	}
+
+	calls := make([]string, 0)
+	var getResourceTagsMock = func(region string, arns []string) (map[string]map[string]string, error) {
+		calls = append(calls, fmt.Sprintf("%s %v", region, arns))
+		return map[string]map[string]string{
+			lambdaARN: {"team": "observability", "env": "prod", "cost-center": "42"},
+		}, nil
+	}
+
+	t.Run("attaches selected tags", func(t *testing.T) {
+		awsRegion = "eu-west-1"
+		defer func() { awsRegion = "" }()
+
+		logs := logsFor()
+		enrichWithResourceTags(logs, getResourceTagsMock, getBucketRegionMock)
+
+		assert.Equal(t, map[string]string{"aws.tag.team": "observability", "aws.tag.env": "prod"}, logs[0].Metadata)
+		assert.Equal(t, map[string]string{"aws.tag.team": "observability", "aws.tag.env": "prod"}, logs[1].Metadata)
+		assert.Nil(t, logs[2].Metadata)
+		assert.Nil(t, logs[4].Metadata)
+		assert.ElementsMatch(t, []string{
+			"us-west-1 [" + lambdaARN + "]",
+			"ap-northeast-1 [" + bucketARN + "]",
+			"us-east-1 [" + distributionARN + "]",
+		}, calls)
+	})
+
+	t.Run("uses cached tags", func(t *testing.T) {
+		calls = calls[:0]
+
+		logs := logsFor()
+		enrichWithResourceTags(logs, getResourceTagsMock, getBucketRegionMock)
+
+		assert.Empty(t, calls)
+		assert.Equal(t, "observability", logs[0].Metadata["aws.tag.team"])
+	})
+
+	t.Run("refreshes expired tags", func(t *testing.T) {
+		calls = calls[:0]
+		resourceTagsCacheTTL = -time.Second
+		resourceTagsCache.entries = make(map[string]resourceTagsCacheEntry)
+
+		enrichWithResourceTags(logsFor(), getResourceTagsMock, getBucketRegionMock)
+		enrichWithResourceTags(logsFor(), getResourceTagsMock, getBucketRegionMock)
+
+		assert.Equal(t, 6, len(calls))
+		assert.Equal(t, 1, bucketRegionCalls)
+	})
+
+	t.Run("all tags", func(t *testing.T) {
+		resourceTagKeys = parseCommaSeparated("*")
+
+		logs := logsFor()
+		enrichWithResourceTags(logs, getResourceTagsMock, getBucketRegionMock)
+
+		assert.Equal(t, map[string]string{"aws.tag.team": "observability", "aws.tag.env": "prod", "aws.tag.cost-center": "42"}, logs[0].Metadata)
+	})
+
+	t.Run("lookup failure", func(t *testing.T) {
+		resourceTagsCacheTTL = defaultResourceTagsCacheTTL
+		resourceTagsCache.entries = make(map[string]resourceTagsCacheEntry)
+		failures := 0
+		var getResourceTagsFailure = func(region string, arns []string) (map[string]map[string]string, error) {
+			failures++
+			return nil, fmt.Errorf("AccessDeniedException")
+		}
+
+		logs := logsFor()
+		enrichWithResourceTags(logs, getResourceTagsFailure, getBucketRegionMock)
+		enrichWithResourceTags(logsFor(), getResourceTagsFailure, getBucketRegionMock)
+
+		assert.Nil(t, logs[0].Metadata)
+		assert.Equal(t, 3, failures)
+		assert.Equal(t, 3, len(resourceTagsCache.entries))
+		assert.True(t, resourceTagsCache.entries[lambdaARN].expires.Before(time.Now().Add(resourceTagsFailureCacheTTL+time.Second)))
+	})
+
+	t.Run("evicts entries", func(t *testing.T) {
+		resourceTagsCacheTTL = defaultResourceTagsCacheTTL
+		resourceTagsCache.entries = make(map[string]resourceTagsCacheEntry)
+		for i := 0; i < resourceTagsCacheSize; i++ {
+			resourceTagsCache.entries[fmt.Sprintf("arn:aws:s3:::bucket-%d", i)] = resourceTagsCacheEntry{expires: time.Now().Add(time.Hour)}
+		}
+		resourceTagsCache.entries["arn:aws:s3:::expired"] = resourceTagsCacheEntry{expires: time.Now().Add(-time.Second)}
+
+		enrichWithResourceTags(logsFor(), getResourceTagsMock, getBucketRegionMock)
+
+		assert.Equal(t, resourceTagsCacheSize, len(resourceTagsCache.entries))
+		assert.NotContains(t, resourceTagsCache.entries, "arn:aws:s3:::expired")
+		assert.Contains(t, resourceTagsCache.entries, lambdaARN)
+		assert.Contains(t, resourceTagsCache.entries, bucketARN)
+	})
+}
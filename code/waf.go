@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/logicmonitor/lm-logs-sdk-go/ingest"
+)
+
+// wafLogPrefix starts the names of the log groups, delivery streams and
+// buckets web ACLs log to.
+const wafLogPrefix = "aws-waf-logs-"
+
+const wafRedactedHeaderValue = "REDACTED"
+
+// wafS3KeyRegex matches the keys of WAF log files delivered to S3 directly or
+// through a Firehose delivery stream.
+var wafS3KeyRegex = regexp.MustCompile(`AWSLogs/(?P<account>\d{12})/WAFLogs/|` + wafLogPrefix)
+
+// wafDropHeaders removes the request headers from WAF logs, and
+// wafRedactHeaders replaces the values of the named headers, or of all headers
+// when it holds *.
+var wafDropHeaders bool
+var wafRedactHeaders []string
+
+// wafRecord holds the fields of a WAF log promoted to attributes or used to
+// find its web ACL.
+type wafRecord struct {
+	Timestamp         int64  `json:"timestamp"`
+	WebACLID          string `json:"webaclId"`
+	TerminatingRuleID string `json:"terminatingRuleId"`
+	Action            string `json:"action"`
+	RuleGroupList     []struct {
+		RuleGroupID     string          `json:"ruleGroupId"`
+		TerminatingRule json.RawMessage `json:"terminatingRule"`
+		NonTerminating  []interface{}   `json:"nonTerminatingMatchingRules"`
+	} `json:"ruleGroupList"`
+	HTTPRequest struct {
+		ClientIP   string `json:"clientIp"`
+		Country    string `json:"country"`
+		URI        string `json:"uri"`
+		HTTPMethod string `json:"httpMethod"`
+	} `json:"httpRequest"`
+}
+
+// isWAFLogGroup reports whether a CloudWatch log group receives WAF logs.
+func isWAFLogGroup(logGroup string) bool {
+	return strings.HasPrefix(logGroup, wafLogPrefix)
+}
+
+// isWAFDeliveryStream reports whether a Firehose delivery stream receives WAF
+// logs.
+func isWAFDeliveryStream(streamARN string) bool {
+	return strings.Contains(streamARN, ":deliverystream/"+wafLogPrefix)
+}
+
+func parseWAFCloudWatchLogs(data events.CloudwatchLogsData) []LMLog {
+	lmBatch := make([]LMLog, 0)
+
+	for _, event := range data.LogEvents {
+		if lmEv, ok := parseWAFLog(event.Message, data.Owner, time.Unix(0, event.Timestamp*1000000)); ok {
+			lmBatch = append(lmBatch, lmEv)
+		}
+	}
+
+	return lmBatch
+}
+
+// parseWAFS3Logs splits a WAF log file delivered to S3 into one event per
+// request.
+func parseWAFS3Logs(request events.S3Event, getContentsFromS3Bucket GetContentFromS3Bucket) ([]LMLog, error) {
+	lmBatch := make([]LMLog, 0)
+
+	bucketName := request.Records[0].S3.Bucket.Name
+	key := request.Records[0].S3.Object.Key
+	content, err := readS3Content(getContentsFromS3Bucket, bucketName, key)
+	if err != nil {
+		return lmBatch, err
+	}
+
+	var owner string
+	if keyMatches := wafS3KeyRegex.FindStringSubmatch(key); keyMatches != nil {
+		owner = keyMatches[wafS3KeyRegex.SubexpIndex("account")]
+	}
+
+	// Firehose concatenates the JSON logs of a delivery without separators.
+	decoder := json.NewDecoder(strings.NewReader(content))
+	for decoder.More() {
+		var message json.RawMessage
+		err := decoder.Decode(&message)
+		if err != nil {
+			return lmBatch, fmt.Errorf("failed to parse waf log file %s: %s", key, err)
+		}
+		if lmEv, ok := parseWAFLog(string(message), owner, request.Records[0].EventTime); ok {
+			lmBatch = append(lmBatch, lmEv)
+		}
+	}
+	return lmBatch, nil
+}
+
+// parseWAFLog returns the event of a WAF log, timestamped with its timestamp
+// and attached to its web ACL. The request headers are dropped or redacted as
+// configured. Logs that cannot be decoded are sent as they are against the
+// account, unless headers must be scrubbed: the headers of logs that cannot be
+// redacted are dropped, and logs whose headers cannot be dropped are not sent,
+// as reported by the returned bool.
+func parseWAFLog(message string, owner string, deliveryTime time.Time) (LMLog, bool) {
+	lmEv := LMLog{
+		Log: ingest.Log{
+			Message:    message,
+			ResourceID: accountResourceID(resolveAccountID(owner)),
+			Timestamp:  deliveryTime,
+		},
+	}
+
+	scrubHeaders := wafDropHeaders || len(wafRedactHeaders) > 0
+
+	var record wafRecord
+	err := json.Unmarshal([]byte(message), &record)
+	if err != nil {
+		if scrubHeaders {
+			fmt.Printf("WARN dropping waf log whose headers cannot be scrubbed: %s\n", err)
+			return lmEv, false
+		}
+		fmt.Printf("WARN failed to parse waf log %s\n", err)
+		return lmEv, true
+	}
+
+	if record.Timestamp > 0 {
+		lmEv.Timestamp = time.Unix(0, record.Timestamp*int64(time.Millisecond))
+	}
+	if validateARN(record.WebACLID) == nil {
+		lmEv.ResourceID = map[string]string{"system.aws.arn": record.WebACLID}
+	}
+	lmEv.Metadata = wafAttributes(record)
+
+	if scrubHeaders {
+		scrubbed, err := scrubWAFHeaders(message, wafDropHeaders)
+		if err != nil && !wafDropHeaders {
+			fmt.Printf("WARN dropping waf log headers that cannot be redacted: %s\n", err)
+			scrubbed, err = scrubWAFHeaders(message, true)
+		}
+		if err != nil {
+			fmt.Printf("WARN dropping waf log whose headers cannot be scrubbed: %s\n", err)
+			return lmEv, false
+		}
+		lmEv.Message = scrubbed
+	}
+	return lmEv, true
+}
+
+func wafAttributes(record wafRecord) map[string]string {
+	matchedRuleGroups := make([]string, 0)
+	for _, ruleGroup := range record.RuleGroupList {
+		terminating := len(ruleGroup.TerminatingRule) > 0 && string(ruleGroup.TerminatingRule) != "null"
+		if terminating || len(ruleGroup.NonTerminating) > 0 {
+			matchedRuleGroups = append(matchedRuleGroups, ruleGroup.RuleGroupID)
+		}
+	}
+
+	fields := map[string]string{
+		"action":                 record.Action,
+		"terminatingRuleId":      record.TerminatingRuleID,
+		"httpRequest.clientIp":   record.HTTPRequest.ClientIP,
+		"httpRequest.country":    record.HTTPRequest.Country,
+		"httpRequest.uri":        record.HTTPRequest.URI,
+		"httpRequest.httpMethod": record.HTTPRequest.HTTPMethod,
+		"ruleGroupList.matches":  strings.Join(matchedRuleGroups, ","),
+	}
+	return attributes(fields)
+}
+
+// scrubWAFHeaders drops httpRequest.headers of a WAF log, or redacts them
+// unless drop is set.
+func scrubWAFHeaders(message string, drop bool) (string, error) {
+	var record map[string]json.RawMessage
+	err := json.Unmarshal([]byte(message), &record)
+	if err != nil {
+		return message, err
+	}
+	if _, ok := record["httpRequest"]; !ok {
+		return message, nil
+	}
+	var httpRequest map[string]json.RawMessage
+	err = json.Unmarshal(record["httpRequest"], &httpRequest)
+	if err != nil {
+		return message, err
+	}
+	if _, ok := httpRequest["headers"]; !ok {
+		return message, nil
+	}
+
+	if drop {
+		delete(httpRequest, "headers")
+	} else {
+		var headers []map[string]string
+		err = json.Unmarshal(httpRequest["headers"], &headers)
+		if err != nil {
+			return message, err
+		}
+		for _, header := range headers {
+			if shouldRedactWAFHeader(header["name"]) {
+				header["value"] = wafRedactedHeaderValue
+			}
+		}
+		httpRequest["headers"], _ = marshalWAFLog(headers)
+	}
+
+	record["httpRequest"], _ = marshalWAFLog(httpRequest)
+	scrubbed, err := marshalWAFLog(record)
+	if err != nil {
+		return message, err
+	}
+	return string(scrubbed), nil
+}
+
+// marshalWAFLog encodes parts of a WAF log without escaping the HTML
+// characters of URIs and headers.
+func marshalWAFLog(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	return bytes.TrimRight(buf.Bytes(), "\n"), err
+}
+
+func shouldRedactWAFHeader(name string) bool {
+	for _, redacted := range wafRedactHeaders {
+		if redacted == "*" || strings.EqualFold(redacted, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+const wafBlockedLog = `{"timestamp":1619416294250,"formatVersion":1,"webaclId":"arn:aws:wafv2:ap-northeast-1:197152445587:regional/webacl/storefront/a1b2c3d4-5678-90ab-cdef-EXAMPLE11111","terminatingRuleId":"AWS-AWSManagedRulesSQLiRuleSet","terminatingRuleType":"MANAGED_RULE_GROUP","action":"BLOCK","httpSourceName":"ALB","ruleGroupList":[{"ruleGroupId":"AWS#AWSManagedRulesCommonRuleSet","terminatingRule":null,"nonTerminatingMatchingRules":[]},{"ruleGroupId":"AWS#AWSManagedRulesSQLiRuleSet","terminatingRule":{"ruleId":"SQLi_QUERYARGUMENTS","action":"BLOCK"},"nonTerminatingMatchingRules":[]}],"httpRequest":{"clientIp":"198.51.100.7","country":"JP","headers":[{"name":"Host","value":"shop.example.com"},{"name":"Authorization","value":"Bearer abc"}],"uri":"/search","args":"q=1&x=<y>","httpVersion":"HTTP/1.1","httpMethod":"GET","requestId":"1-6086b2e6-1a2b3c4d5e6f"}}`
+
+const wafAllowedLog = `{"timestamp":1619416295000,"webaclId":"arn:aws:wafv2:ap-northeast-1:197152445587:regional/webacl/storefront/a1b2c3d4-5678-90ab-cdef-EXAMPLE11111","terminatingRuleId":"Default_Action","action":"ALLOW","ruleGroupList":[],"httpRequest":{"clientIp":"198.51.100.8","country":"US","headers":[],"uri":"/","httpMethod":"GET"}}`
+
+func TestParseWAFLog(t *testing.T) {
+	lmEv, _ := parseWAFLog(wafBlockedLog, "197152445587", time.Time{})
+
+	assert.Equal(t, wafBlockedLog, lmEv.Message)
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:wafv2:ap-northeast-1:197152445587:regional/webacl/storefront/a1b2c3d4-5678-90ab-cdef-EXAMPLE11111"}, lmEv.ResourceID)
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 51, 34, 250000000, time.UTC), lmEv.Timestamp.UTC())
+	assert.Equal(t, map[string]string{
+		"action":                 "BLOCK",
+		"terminatingRuleId":      "AWS-AWSManagedRulesSQLiRuleSet",
+		"httpRequest.clientIp":   "198.51.100.7",
+		"httpRequest.country":    "JP",
+		"httpRequest.uri":        "/search",
+		"httpRequest.httpMethod": "GET",
+		"ruleGroupList.matches":  "AWS#AWSManagedRulesSQLiRuleSet",
+	}, lmEv.Metadata)
+
+	classicLog, _ := parseWAFLog(`{"timestamp":1619416294250,"webaclId":"0a1b2c3d-4e5f-6789-abcd-ef0123456789","action":"ALLOW"}`, "197152445587", time.Time{})
+	assert.Equal(t, accountResourceID("197152445587"), classicLog.ResourceID)
+}
+
+func TestParseWAFLogHeaders(t *testing.T) {
+	t.Run("redacted", func(t *testing.T) {
+		wafRedactHeaders = parseCommaSeparated("authorization, cookie")
+		defer func() { wafRedactHeaders = nil }()
+
+		lmEv, _ := parseWAFLog(wafBlockedLog, "197152445587", time.Time{})
+
+		assert.Contains(t, lmEv.Message, `{"name":"Authorization","value":"REDACTED"}`)
+		assert.Contains(t, lmEv.Message, `{"name":"Host","value":"shop.example.com"}`)
+		assert.Contains(t, lmEv.Message, `"args":"q=1&x=<y>"`)
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		wafDropHeaders = true
+		defer func() { wafDropHeaders = false }()
+
+		lmEv, _ := parseWAFLog(wafBlockedLog, "197152445587", time.Time{})
+
+		var record struct {
+			Action      string                 `json:"action"`
+			HTTPRequest map[string]interface{} `json:"httpRequest"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(lmEv.Message), &record))
+		assert.Equal(t, "BLOCK", record.Action)
+		assert.NotContains(t, record.HTTPRequest, "headers")
+		assert.Equal(t, "198.51.100.7", record.HTTPRequest["clientIp"])
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		wafRedactHeaders = parseCommaSeparated("authorization")
+		defer func() { wafRedactHeaders = nil }()
+
+		malformedHeaders := `{"timestamp":1619416294250,"action":"BLOCK","httpRequest":{"clientIp":"198.51.100.7","headers":"Authorization: Bearer abc","uri":"/"}}`
+		lmEv, ok := parseWAFLog(malformedHeaders, "197152445587", time.Time{})
+
+		assert.True(t, ok)
+		assert.NotContains(t, lmEv.Message, "Bearer abc")
+		assert.Equal(t, `{"action":"BLOCK","httpRequest":{"clientIp":"198.51.100.7","uri":"/"},"timestamp":1619416294250}`, lmEv.Message)
+
+		_, ok = parseWAFLog(`{"timestamp":1619416294250,"httpRequest":"Authorization: Bearer abc"}`, "197152445587", time.Time{})
+		assert.False(t, ok)
+
+		_, ok = parseWAFLog(`{"httpRequest":{"headers":[{"name":"Authorization","value":"Bearer abc"}]`, "197152445587", time.Time{})
+		assert.False(t, ok)
+	})
+}
+
+func TestParseWAFS3Logs(t *testing.T) {
+	keys := []string{
+		"AWSLogs/197152445587/WAFLogs/ap-northeast-1/storefront/2021/04/26/05/50/197152445587_waflogs_ap-northeast-1_storefront_20210426T0550Z_a1b2c3d4.log.gz",
+		"waf/2021/04/26/05/aws-waf-logs-storefront-1-2021-04-26-05-50-00-a1b2c3d4-e5f6-7890-abcd-ef0123456789",
+	}
+	for _, key := range keys {
+		var getContentsFromS3BucketMock = func(bucket string, fileName string) string {
+			return gzipString(wafBlockedLog + wafAllowedLog + "\n")
+		}
+
+		assert.Equal(t, "waf", s3EventType(s3EventFor("waf-logs", key)), key)
+
+		lmEvents, err := parseWAFS3Logs(s3EventFor("waf-logs", key), getContentsFromS3BucketMock)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(lmEvents))
+		assert.Equal(t, wafBlockedLog, lmEvents[0].Message)
+		assert.Equal(t, "ALLOW", lmEvents[1].Metadata["action"])
+	}
+}
+
+func TestParseWAFCloudWatchLogs(t *testing.T) {
+	lmEvents := parseCloudWatchLogs(cloudWatchEventFor(events.CloudwatchLogsData{
+		Owner:     "197152445587",
+		LogGroup:  "aws-waf-logs-storefront",
+		LogStream: "ap-northeast-1_storefront_0",
+		LogEvents: []events.CloudwatchLogsLogEvent{{ID: "1", Timestamp: 1619416294300, Message: wafBlockedLog}},
+	}))
+
+	assert.Equal(t, 1, len(lmEvents))
+	assert.Equal(t, map[string]string{"system.aws.arn": "arn:aws:wafv2:ap-northeast-1:197152445587:regional/webacl/storefront/a1b2c3d4-5678-90ab-cdef-EXAMPLE11111"}, lmEvents[0].ResourceID)
+	assert.Equal(t, "BLOCK", lmEvents[0].Metadata["action"])
+}
+
+func TestParseWAFFirehoseRecords(t *testing.T) {
+	lmEvents := parseStreamRecord([]byte(wafAllowedLog+"\n"), "arn:aws:firehose:ap-northeast-1:197152445587:deliverystream/aws-waf-logs-storefront", time.Time{})
+
+	assert.Equal(t, 1, len(lmEvents))
+	assert.Equal(t, wafAllowedLog, lmEvents[0].Message)
+	assert.Equal(t, "ALLOW", lmEvents[0].Metadata["action"])
+	assert.Equal(t, time.Date(2021, time.April, 26, 5, 51, 35, 0, time.UTC), lmEvents[0].Timestamp.UTC())
+}